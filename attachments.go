@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// attachment is an image or file attached to a note.
+type attachment struct {
+	// Name is the file name the note refers to it by, after the folder it's in
+	// if the note refers to that too.
+	Name string
+	// Path is where the file is on disk.
+	Path string
+}
+
+// Bear keeps attachments in folders named by their ID under these folders,
+// in the Local Files folder next to its database.
+var bearAttachmentDirs = []string{"Note Images", "Note Files"}
+
+// referenced reports whether a note's text refers to an attachment with the name.
+func referenced(text []byte, name string) bool {
+	return bytes.Contains(text, []byte(name)) || bytes.Contains(text, []byte(url.PathEscape(name)))
+}
+
+// localLink matches the target of a markdown image or link.
+var localLink = regexp.MustCompile(`\]\(([^)\s]+)\)`)
+
+// folderAttachments finds the files that exported text links to, relative to the folder it is in.
+func folderAttachments(dir string, text []byte) []attachment {
+	attachments := []attachment{}
+	seen := map[string]bool{}
+
+	for _, m := range localLink.FindAllSubmatch(text, -1) {
+		src, err := url.PathUnescape(string(m[1]))
+		if err != nil || strings.Contains(src, "://") || strings.HasPrefix(src, "#") || path.IsAbs(src) {
+			continue
+		}
+
+		p := filepath.Join(dir, filepath.FromSlash(src))
+		if seen[p] {
+			continue
+		}
+		if info, err := os.Stat(p); err != nil || info.IsDir() {
+			continue
+		}
+
+		seen[p] = true
+		attachments = append(attachments, attachment{Name: path.Base(src), Path: p})
+	}
+
+	return attachments
+}
+
+// attachmentDir is the folder under the image dir that a note's attachments are copied to.
+// Each note has its own, named after its ID, since Bear names every pasted image
+// image.png and notes would otherwise overwrite each other's.
+func attachmentDir(n note) string {
+	return slugify(n.ID)
+}
+
+// copyAttachments copies a note's attachments into dir, processing images with the
+// pipeline. Files whose content is already there are left alone, so only new or
+// changed files are copied. It returns the images that were processed, by name.
+func copyAttachments(n note, dir string, p imagePipeline, st *state) (map[string]processedImage, error) {
+	if len(n.Attachments) == 0 {
+		return nil, nil
+	}
+
+	images := map[string]processedImage{}
+	for _, a := range n.Attachments {
+		dst := filepath.Join(dir, filepath.FromSlash(a.Name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return nil, err
+		}
+
+		if p.Process && processable(a.Name) {
+			b, err := ioutil.ReadFile(a.Path)
+			if err != nil {
+				return nil, err
+			}
+
+			pi, err := p.plan(a.Name, b)
+			if err != nil {
+				log.Warnf("Can't process %s from %s, copying it as it is: %s", a.Name, n.Title, err)
+			} else {
+				images[a.Name] = pi
+
+				// The output can't be compared with the original, so remember what it was made from.
+				key := p.key(noteHash(b))
+				if _, err := os.Stat(dst); err == nil && st.image(dst) == key {
+					continue
+				}
+
+				log.Infof("Processing %s from %s to %s", a.Name, n.Title, dst)
+				if err := p.process(b, dir, pi); err != nil {
+					return nil, err
+				}
+				if err := st.setImage(dst, key); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		src, err := fileHash(a.Path)
+		if err != nil {
+			return nil, err
+		}
+		existing, err := fileHash(dst)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if src == existing {
+			continue
+		}
+
+		log.Infof("Copying %s from %s to %s", a.Name, n.Title, dst)
+		if err := copyFile(a.Path, dst); err != nil {
+			return nil, err
+		}
+	}
+
+	return images, nil
+}
+
+// fileHash identifies the content of a file.
+func fileHash(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyFile copies a file into the site.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeAtomic(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+// writeAtomic writes a file in the site through a temporary file, so Hugo never serves half of it.
+func writeAtomic(dst string, write func(io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst))
+	if err != nil {
+		return err
+	}
+
+	// Temporary files are only readable by their owner, unlike the rest of the site.
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyAttachments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "bear", "photo.jpg")
+	require.NoError(t, os.MkdirAll(filepath.Dir(src), 0755))
+	require.NoError(t, ioutil.WriteFile(src, []byte("photo"), 0644))
+
+	n := note{Title: "Note", Attachments: []attachment{{Name: "photo.jpg", Path: src}}}
+	static := filepath.Join(dir, "static", "img")
+	dst := filepath.Join(static, "photo.jpg")
+	st := &state{Notes: map[string]noteState{}}
+
+	_, err = copyAttachments(n, static, imagePipeline{}, st)
+	require.NoError(t, err)
+	f, err := ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "photo", string(f))
+
+	info, err := os.Stat(dst)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	// An unchanged file isn't copied again.
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(dst, old, old))
+	_, err = copyAttachments(n, static, imagePipeline{}, st)
+	require.NoError(t, err)
+	info, err = os.Stat(dst)
+	require.NoError(t, err)
+	require.True(t, info.ModTime().Equal(old))
+
+	// A changed file is.
+	require.NoError(t, ioutil.WriteFile(src, []byte("edited photo"), 0644))
+	_, err = copyAttachments(n, static, imagePipeline{}, st)
+	require.NoError(t, err)
+	f, err = ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "edited photo", string(f))
+
+	// A missing file is an error.
+	n.Attachments[0].Path = filepath.Join(dir, "missing.jpg")
+	_, err = copyAttachments(n, static, imagePipeline{}, st)
+	require.Error(t, err)
+}
+
+func TestUpdateHugoAttachments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	hugoDir := filepath.Join(dir, "site")
+	site := hugoTest{dir: hugoDir, routes: []route{{Tag: "blog", ContentDir: "content/blog", ImageDir: "/img/posts", Attachments: true}}}
+
+	// Bear names every pasted image the same.
+	notes := []note{}
+	for _, id := range []string{"NOTE-1", "NOTE-2"} {
+		src := filepath.Join(dir, "bear", id, "image.png")
+		require.NoError(t, os.MkdirAll(filepath.Dir(src), 0755))
+		require.NoError(t, ioutil.WriteFile(src, []byte(id), 0644))
+
+		notes = append(notes, note{
+			ID:          id,
+			Title:       id,
+			Tagged:      true,
+			Markup:      bear2,
+			BodyRaw:     []byte("# " + id + "\n#blog\n\n![](image.png)"),
+			Attachments: []attachment{{Name: "image.png", Path: src}},
+		})
+	}
+
+	// Bear 1 keeps each image in a folder named by its ID, so a note can have two images with the same name.
+	bear1 := note{ID: "NOTE-3", Title: "NOTE-3", Tagged: true, Markup: bear1, BodyRaw: []byte("# NOTE-3\n#blog\n\n[image:IMG-1/image.png]\n[image:IMG-2/image.png]\n[file:FILE-1/image.png]")}
+	for _, id := range []string{"IMG-1", "IMG-2", "FILE-1"} {
+		src := filepath.Join(dir, "bear", id, "image.png")
+		require.NoError(t, os.MkdirAll(filepath.Dir(src), 0755))
+		require.NoError(t, ioutil.WriteFile(src, []byte(id), 0644))
+		bear1.Attachments = append(bear1.Attachments, attachment{Name: id + "/image.png", Path: src})
+	}
+	notes = append(notes, bear1)
+	runUpdateHugo(t, site, notes...)
+
+	for _, id := range []string{"IMG-1", "IMG-2", "FILE-1"} {
+		f, err := ioutil.ReadFile(filepath.Join(hugoDir, "static/img/posts/note-3", id, "image.png"))
+		require.NoError(t, err)
+		require.Equal(t, id, string(f))
+	}
+	f, err := ioutil.ReadFile(filepath.Join(hugoDir, "content/blog/note-3.md"))
+	require.NoError(t, err)
+	require.Contains(t, string(f), "\n![](/img/posts/note-3/IMG-1/image.png)\n![](/img/posts/note-3/IMG-2/image.png)\n[image.png](/img/posts/note-3/FILE-1/image.png)")
+
+	for _, id := range []string{"note-1", "note-2"} {
+		f, err := ioutil.ReadFile(filepath.Join(hugoDir, "static/img/posts", id, "image.png"))
+		require.NoError(t, err)
+		require.Equal(t, strings.ToUpper(id), string(f))
+
+		f, err = ioutil.ReadFile(filepath.Join(hugoDir, "content/blog", id+".md"))
+		require.NoError(t, err)
+		require.Contains(t, string(f), "\n![](/img/posts/"+id+"/image.png)")
+	}
+}
+
+func TestFolderAttachments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, f := range []string{"assets/my photo.jpg", "assets/report.pdf"} {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte(f), 0644))
+	}
+
+	text := []byte("![](assets/my%20photo.jpg)<!-- {\"width\":100} -->\n" +
+		"[Report](assets/report.pdf) and [again](assets/report.pdf)\n" +
+		"![](assets/missing.png) [web](https://example.com/a.png) [anchor](#heading) ![](/abs.png)")
+
+	require.Equal(t, []attachment{
+		{Name: "my photo.jpg", Path: filepath.Join(dir, "assets", "my photo.jpg")},
+		{Name: "report.pdf", Path: filepath.Join(dir, "assets", "report.pdf")},
+	}, folderAttachments(dir, text))
+}
+
+func TestSQLiteAttachments(t *testing.T) {
+	files, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(files)
+
+	for _, f := range []string{"Note Images/IMG-1/photo.jpg", "Note Files/FILE-1/report.pdf", "Note Images/IMG-2/removed.jpg"} {
+		p := filepath.Join(files, filepath.FromSlash(f))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte(f), 0644))
+	}
+
+	db := newTestBear(t, ":memory:", "bear1")
+	defer db.Close()
+
+	db.write("NOTE-1", "Note", "# Note\n#blog\n\n[image:IMG-1/photo.jpg]\n[file:FILE-1/report.pdf]\n[image:IMG-3/lost.jpg]", time.Now())
+	var pk int64
+	require.NoError(t, db.Get(&pk, "SELECT Z_PK FROM ZSFNOTE WHERE ZUNIQUEIDENTIFIER = 'NOTE-1'"))
+	db.MustExec(`INSERT INTO ZSFNOTEFILE (ZNOTE, ZUNIQUEIDENTIFIER, ZFILENAME) VALUES
+		(?, 'IMG-1', 'photo.jpg'), (?, 'FILE-1', 'report.pdf'), (?, 'IMG-2', 'removed.jpg'), (?, 'IMG-3', 'lost.jpg')`, pk, pk, pk, pk)
+
+	src := &sqliteSource{db: db.DB, schema: db.schema, files: files}
+	n := note{PK: pk, Title: "Note"}
+	require.NoError(t, src.load(&n))
+
+	require.Equal(t, []attachment{
+		{Name: "IMG-1/photo.jpg", Path: filepath.Join(files, "Note Images", "IMG-1", "photo.jpg")},
+		{Name: "FILE-1/report.pdf", Path: filepath.Join(files, "Note Files", "FILE-1", "report.pdf")},
+	}, n.Attachments)
+}
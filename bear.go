@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	sql "github.com/jmoiron/sqlx"
+)
+
+const likeEscapeChar = `\`
+
+// markup is the flavour of markdown a note is written in.
+type markup int
+
+const (
+	// Bear 1 markdown compatibility mode, with images as [image:...].
+	bear1 markup = iota
+	// Bear 2 markdown, with images as ![](...) followed by <!-- {...} --> metadata.
+	bear2
+	// Bear 1 with markdown compatibility mode turned off, see polar.go.
+	polar
+)
+
+// schema describes the parts of the Bear database that differ between versions.
+type schema struct {
+	Version int
+	Markup  markup
+
+	// Bear links notes to their tags through a Core Data join table whose
+	// name and columns are numbered by entity, which changed in Bear 2.
+	NoteTags     string
+	NoteTagsNote string
+	NoteTagsTag  string
+}
+
+// Join tables of the known Bear versions.
+var schemas = map[string]schema{
+	"Z_7TAGS": {Version: 1, Markup: bear1, NoteTags: "Z_7TAGS", NoteTagsNote: "Z_7NOTES", NoteTagsTag: "Z_14TAGS"},
+	"Z_5TAGS": {Version: 2, Markup: bear2, NoteTags: "Z_5TAGS", NoteTagsNote: "Z_5NOTES", NoteTagsTag: "Z_13TAGS"},
+}
+
+// detectSchema inspects the Bear database to work out which version of Bear wrote it.
+func detectSchema(db *sql.DB) (schema, error) {
+	tables := []string{}
+	if err := db.Select(&tables, "SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE 'Z\\_%TAGS' ESCAPE '\\'"); err != nil {
+		return schema{}, err
+	}
+
+	for _, t := range tables {
+		s, ok := schemas[t]
+		if !ok {
+			continue
+		}
+
+		// Make sure the columns are what this version expects before trusting it.
+		cols := map[string]bool{}
+		rows, err := db.Queryx(fmt.Sprintf("PRAGMA table_info(%s)", t))
+		if err != nil {
+			return schema{}, err
+		}
+		for rows.Next() {
+			c := map[string]interface{}{}
+			if err := rows.MapScan(c); err != nil {
+				rows.Close()
+				return schema{}, err
+			}
+			cols[fmt.Sprintf("%s", c["name"])] = true
+		}
+		if err := rows.Err(); err != nil {
+			return schema{}, err
+		}
+
+		if cols[s.NoteTagsNote] && cols[s.NoteTagsTag] {
+			return s, nil
+		}
+	}
+
+	return schema{}, fmt.Errorf("unrecognised Bear database, found tag tables %v", tables)
+}
+
+// taggedQuery is a condition on ZSFNOTE matching notes with a tag or any tag nested under it.
+// It takes the tag and a LIKE pattern for its nested tags as parameters.
+func (s schema) taggedQuery() string {
+	return fmt.Sprintf(`EXISTS (SELECT 1 FROM %[1]s JOIN ZSFNOTETAG ON ZSFNOTETAG.Z_PK = %[1]s.%[3]s
+	WHERE %[1]s.%[2]s = ZSFNOTE.Z_PK AND (ZSFNOTETAG.ZTITLE = ? COLLATE NOCASE OR ZSFNOTETAG.ZTITLE LIKE ? ESCAPE '%[4]s'))`,
+		s.NoteTags, s.NoteTagsNote, s.NoteTagsTag, likeEscapeChar)
+}
+
+// noteTagsQuery selects the titles of all the tags of a note, given its primary key.
+func (s schema) noteTagsQuery() string {
+	return fmt.Sprintf(`SELECT ZSFNOTETAG.ZTITLE FROM ZSFNOTETAG JOIN %[1]s ON ZSFNOTETAG.Z_PK = %[1]s.%[3]s
+	WHERE %[1]s.%[2]s = ? ORDER BY ZSFNOTETAG.ZTITLE`,
+		s.NoteTags, s.NoteTagsNote, s.NoteTagsTag)
+}
+
+// nestedTagPattern is a LIKE pattern matching every tag nested under tag.
+func nestedTagPattern(tag string) string {
+	r := strings.NewReplacer(likeEscapeChar, likeEscapeChar+likeEscapeChar, "%", likeEscapeChar+"%", "_", likeEscapeChar+"_")
+	return r.Replace(tag) + "/%"
+}
+
+// leafTags drops any tag that is the parent of another tag in the list.
+// Bear links a note to every parent of its nested tags, which would otherwise
+// show up as extra categories.
+func leafTags(tags []string) []string {
+	sorted := append([]string{}, tags...)
+	sort.Strings(sorted)
+
+	leaves := []string{}
+	for _, t := range sorted {
+		if !hasNested(sorted, t) {
+			leaves = append(leaves, t)
+		}
+	}
+
+	return leaves
+}
+
+// hasNested reports whether any of the tags is nested under parent.
+func hasNested(tags []string, parent string) bool {
+	for _, t := range tags {
+		if strings.HasPrefix(strings.ToLower(t), strings.ToLower(parent)+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// coreDataEpoch is the reference date that Core Data timestamps count from.
+var coreDataEpoch = time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// coreDataTime is a timestamp stored by Core Data as seconds since 2001-01-01 UTC.
+type coreDataTime struct {
+	time.Time
+
+	// The seconds as they were stored, when the time was read from Bear.
+	raw     float64
+	scanned bool
+}
+
+// Scan implements sql.Scanner for Core Data timestamp columns.
+func (t *coreDataTime) Scan(v interface{}) error {
+	switch s := v.(type) {
+	case nil:
+		*t = coreDataTime{}
+		return nil
+	case float64:
+		t.raw = s
+	case int64:
+		t.raw = float64(s)
+	case time.Time:
+		// The SQLite driver assumes whole numbers in TIMESTAMP columns are
+		// Unix times, so undo that to get back the Core Data seconds.
+		t.raw = float64(s.Unix())
+	default:
+		return fmt.Errorf("unsupported Core Data timestamp type %T", v)
+	}
+
+	t.Time = fromCoreData(t.raw)
+	t.scanned = true
+	return nil
+}
+
+// seconds returns the timestamp as Core Data stores it. Times read from Bear give
+// back exactly what was stored, because converting them to a time and back can come
+// out a little earlier, which would match the same note again as a high water mark.
+func (t coreDataTime) seconds() float64 {
+	if t.scanned {
+		return t.raw
+	}
+
+	return toCoreData(t.Time)
+}
+
+// after reports whether t is later than u, comparing them as Core Data stores them.
+func (t coreDataTime) after(u coreDataTime) bool {
+	return t.seconds() > u.seconds()
+}
+
+// fromCoreData converts seconds since the Core Data epoch to a time.
+func fromCoreData(s float64) time.Time {
+	return coreDataEpoch.Add(time.Duration(s * float64(time.Second)))
+}
+
+// toCoreData converts a time to seconds since the Core Data epoch.
+// The zero time converts to the earliest possible timestamp so that
+// it can be used as a high water mark that matches every note.
+func toCoreData(t time.Time) float64 {
+	if t.IsZero() {
+		return -1 << 53
+	}
+
+	return t.Sub(coreDataEpoch).Seconds()
+}
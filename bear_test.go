@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	sql "github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoreDataTime(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+		exp  time.Time
+	}{
+		{"null", nil, time.Time{}},
+		{"epoch", float64(0), time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"fractional", float64(578246121.5), time.Date(2019, 4, 29, 15, 55, 21, 5e8, time.UTC)},
+		{"integer", int64(578246121), time.Date(2019, 4, 29, 15, 55, 21, 0, time.UTC)},
+		{"driver timestamp", time.Unix(578246121, 0), time.Date(2019, 4, 29, 15, 55, 21, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got coreDataTime
+			require.NoError(t, got.Scan(test.in))
+			require.True(t, test.exp.Equal(got.Time), "expected %s, got %s", test.exp, got.Time)
+
+			if !test.exp.IsZero() {
+				require.Equal(t, test.exp, fromCoreData(toCoreData(test.exp)).UTC())
+			}
+		})
+	}
+}
+
+func TestLeafTags(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		exp  []string
+	}{
+		{"empty", nil, []string{}},
+		{"single", []string{"blog"}, []string{"blog"}},
+		{"nested", []string{"blog/travel", "blog", "blog/travel/japan"}, []string{"blog/travel/japan"}},
+		{"siblings", []string{"blog", "blog/a", "blog/b", "other"}, []string{"blog/a", "blog/b", "other"}},
+		{"shared prefix", []string{"blog", "blogging"}, []string{"blog", "blogging"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.exp, leafTags(test.in))
+		})
+	}
+}
+
+func TestDetectSchema(t *testing.T) {
+	tests := []struct {
+		fixture string
+		exp     int
+	}{
+		{"bear1", 1},
+		{"bear2", 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			db := newTestBear(t, ":memory:", test.fixture)
+			defer db.Close()
+
+			require.Equal(t, test.exp, db.schema.Version)
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		db, err := sql.Connect("sqlite3", ":memory:")
+		require.NoError(t, err)
+		defer db.Close()
+
+		db.MustExec("CREATE TABLE ZSFNOTE (Z_PK INTEGER PRIMARY KEY)")
+
+		_, err = detectSchema(db)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// document is the body of a note split into lines, knowing which lines are code.
+// Transforms only change prose, so code samples are exported exactly as written.
+type document struct {
+	lines [][]byte
+	// code marks the lines that are in fenced or indented code blocks, including the fences.
+	code []bool
+}
+
+// parseDocument finds the code blocks in the lines of a note. The document shares the lines.
+func parseDocument(lines [][]byte) document {
+	d := document{lines: lines, code: make([]bool, len(lines))}
+
+	var fence []byte
+	// Indented code needs a blank line before it, and indented lines after a list are part of the list.
+	blank, list, indented := true, false, false
+	for i, l := range lines {
+		trimmed := bytes.TrimLeft(l, " \t")
+
+		switch {
+		case fence != nil:
+			d.code[i] = true
+			if bytes.HasPrefix(trimmed, fence) && len(bytes.TrimSpace(bytes.TrimLeft(trimmed, string(fence[:1])))) == 0 {
+				fence = nil
+			}
+			continue
+
+		case bytes.HasPrefix(trimmed, []byte("```")) || bytes.HasPrefix(trimmed, []byte("~~~")):
+			d.code[i] = true
+			fence = trimmed[:len(trimmed)-len(bytes.TrimLeft(trimmed, string(trimmed[:1])))]
+			blank, list, indented = false, false, false
+			continue
+		}
+
+		isBlank := len(bytes.TrimSpace(l)) == 0
+		isIndented := bytes.HasPrefix(l, []byte("\t")) || bytes.HasPrefix(l, []byte("    "))
+
+		switch {
+		case isBlank:
+			d.code[i] = indented
+			blank = true
+			continue
+		case isIndented && (indented || (blank && !list)):
+			d.code[i] = true
+			indented = true
+		default:
+			if indented {
+				d.endIndented(i)
+			}
+			indented = false
+			list = isListItem(trimmed) || (isIndented && list)
+		}
+
+		blank = false
+	}
+	if indented {
+		d.endIndented(len(lines))
+	}
+
+	return d
+}
+
+// endIndented unmarks the blank lines at the end of an indented code block
+// that ends before line i, since they aren't part of it.
+func (d document) endIndented(i int) {
+	for i--; i >= 0 && d.code[i] && len(bytes.TrimSpace(d.lines[i])) == 0; i-- {
+		d.code[i] = false
+	}
+}
+
+// isListItem reports whether a line, without its indentation, starts a list item.
+func isListItem(l []byte) bool {
+	for _, m := range []string{"- ", "* ", "+ "} {
+		if bytes.HasPrefix(l, []byte(m)) {
+			return true
+		}
+	}
+
+	digits := len(l) - len(bytes.TrimLeft(l, "0123456789"))
+	return digits > 0 && (bytes.HasPrefix(l[digits:], []byte(". ")) || bytes.HasPrefix(l[digits:], []byte(") ")))
+}
+
+// prose replaces the prose in every line that isn't code with the result of f,
+// skipping inline code spans.
+func (d document) prose(f func([]byte) []byte) {
+	for i, l := range d.lines {
+		if !d.code[i] {
+			d.lines[i] = inlineProse(l, f)
+		}
+	}
+}
+
+// bytes joins the lines of the document back together.
+func (d document) bytes() []byte {
+	return bytes.Join(d.lines, []byte("\n"))
+}
+
+// words counts the words outside code blocks, leaving out markup such as list bullets.
+func (d document) words() int {
+	n := 0
+	for i, l := range d.lines {
+		if d.code[i] {
+			continue
+		}
+		for _, w := range bytes.Fields(l) {
+			if bytes.IndexFunc(w, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+				n++
+			}
+		}
+	}
+
+	return n
+}
+
+// inlineProse replaces the parts of a line outside inline code spans with the result of f.
+func inlineProse(l []byte, f func([]byte) []byte) []byte {
+	out := []byte{}
+	start := 0
+	for i := 0; i < len(l); {
+		if l[i] != '`' {
+			i++
+			continue
+		}
+
+		// A code span is closed by a run of the same number of backticks.
+		n := len(l[i:]) - len(bytes.TrimLeft(l[i:], "`"))
+		end := closingBackticks(l[i+n:], n)
+		if end < 0 {
+			i += n
+			continue
+		}
+
+		out = append(out, f(l[start:i])...)
+		out = append(out, l[i:i+n+end+n]...)
+		i += n + end + n
+		start = i
+	}
+
+	return append(out, f(l[start:])...)
+}
+
+// closingBackticks finds the run of exactly n backticks that closes a code span, or -1.
+func closingBackticks(l []byte, n int) int {
+	for i := 0; i < len(l); {
+		if l[i] != '`' {
+			i++
+			continue
+		}
+
+		run := len(l[i:]) - len(bytes.TrimLeft(l[i:], "`"))
+		if run == n {
+			return i
+		}
+		i += run
+	}
+
+	return -1
+}
+
+// stripCode removes inline code spans from a line.
+func stripCode(l []byte) []byte {
+	out := []byte{}
+	inlineProse(l, func(p []byte) []byte {
+		out = append(out, p...)
+		return p
+	})
+
+	return out
+}
+
+// punctuation maps smart punctuation to the plain characters to replace it with.
+// It is configured as space separated from=to pairs, for example `“=" ”="`.
+type punctuation map[string]string
+
+// defaultPunctuation straightens the quotes and apostrophes macOS makes smart and
+// spells out dashes and ellipses, which Hugo's typographer turns back into the
+// right characters.
+var defaultPunctuation = punctuation{
+	"“": `"`,
+	"”": `"`,
+	"‘": "'",
+	"’": "'",
+	"–": "--",
+	"—": "---",
+	"…": "...",
+}
+
+// Decode implements envconfig.Decoder.
+func (p *punctuation) Decode(v string) error {
+	*p = punctuation{}
+	for _, pair := range strings.Fields(v) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid punctuation %q, expected from=to", pair)
+		}
+		(*p)[kv[0]] = kv[1]
+	}
+
+	return nil
+}
+
+// punctuate replaces smart punctuation in the prose of the document.
+// Unset punctuation uses the defaults, while empty punctuation leaves the text alone.
+func (d document) punctuate(p punctuation) {
+	if p == nil {
+		p = defaultPunctuation
+	}
+	if len(p) == 0 {
+		return
+	}
+
+	// Longer punctuation goes first so it wins over any punctuation it starts with.
+	from := []string{}
+	for f := range p {
+		from = append(from, f)
+	}
+	sort.Slice(from, func(i, j int) bool {
+		if len(from[i]) != len(from[j]) {
+			return len(from[i]) > len(from[j])
+		}
+		return from[i] < from[j]
+	})
+
+	pairs := []string{}
+	for _, f := range from {
+		pairs = append(pairs, f, p[f])
+	}
+	r := strings.NewReplacer(pairs...)
+
+	d.prose(func(b []byte) []byte {
+		return []byte(r.Replace(string(b)))
+	})
+}
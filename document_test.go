@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDocument(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		exp  []bool
+	}{
+		{"prose", "a\nb", []bool{false, false}},
+		{"fenced", "a\n```go\nx := 1\n```\nb", []bool{false, true, true, true, false}},
+		{"tildes", "~~~\n```\n~~~\nb", []bool{true, true, true, false}},
+		{"longer fence", "````\n```\n````\nb", []bool{true, true, true, false}},
+		{"unclosed fence", "```\na\nb", []bool{true, true, true}},
+		{"indented", "a\n\n    code\n\tmore\n\n    code\n\nb", []bool{false, false, true, true, true, true, false, false}},
+		{"indented without blank line", "a\n    b", []bool{false, false}},
+		{"nested list", "- a\n\t- b\n\n\t- c", []bool{false, false, false, false}},
+		{"numbered list", "1. a\n\n    b", []bool{false, false, false}},
+		{"start of note", "    code\nb", []bool{true, false}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := parseDocument(bytes.Split([]byte(test.in), []byte("\n")))
+			require.Equal(t, test.exp, d.code)
+		})
+	}
+}
+
+func TestInlineProse(t *testing.T) {
+	upper := func(b []byte) []byte { return bytes.ToUpper(b) }
+
+	tests := []struct {
+		name string
+		in   string
+		exp  string
+	}{
+		{"no code", "abc", "ABC"},
+		{"code span", "a `b` c", "A `b` C"},
+		{"double backticks", "a ``b ` c`` d", "A ``b ` c`` D"},
+		{"unclosed", "a `b", "A `B"},
+		{"mismatched", "a ``b` c", "A ``B` C"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.exp, string(inlineProse([]byte(test.in), upper)))
+		})
+	}
+
+	require.Equal(t, "a  c", string(stripCode([]byte("a `b` c"))))
+}
+
+func TestPunctuate(t *testing.T) {
+	in := "“Quoted” isn’t – or — … `fmt.Println(“code”)`\n```\n“fenced”\n```"
+
+	tests := []struct {
+		name string
+		cfg  string
+		exp  string
+	}{
+		{"default", "", "\"Quoted\" isn't -- or --- ... `fmt.Println(“code”)`\n```\n“fenced”\n```"},
+		{"quotes only", "“=\" ”=\"", "\"Quoted\" isn’t – or — … `fmt.Println(“code”)`\n```\n“fenced”\n```"},
+		{"custom", "“=« ”=» ’=", "«Quoted» isnt – or — … `fmt.Println(“code”)`\n```\n“fenced”\n```"},
+		{"disabled", " ", in},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var p punctuation
+			if test.cfg != "" {
+				require.NoError(t, p.Decode(test.cfg))
+			}
+
+			d := parseDocument(bytes.Split([]byte(in), []byte("\n")))
+			d.punctuate(p)
+			require.Equal(t, test.exp, string(d.bytes()))
+		})
+	}
+
+	// Each default is replaced in prose but not in code.
+	for from, to := range defaultPunctuation {
+		d := parseDocument(bytes.Split([]byte("a"+from+"b `"+from+"`\n```\n"+from+"\n```"), []byte("\n")))
+		d.punctuate(nil)
+		require.Equal(t, "a"+to+"b `"+from+"`\n```\n"+from+"\n```", string(d.bytes()), from)
+	}
+
+	var p punctuation
+	require.Error(t, p.Decode("“"))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// figure is an image in a note, with how Bear shows it. Image templates are
+// executed with a figure, using [[ and ]] as delimiters so that they can
+// contain Hugo shortcodes, for example:
+//
+//	{{< img src="[[ .Src ]]" alt="[[ .Alt ]]" width="[[ .Width ]]" >}}
+type figure struct {
+	Src     string
+	Alt     string
+	Caption string
+	// Width Bear shows the image at, 0 for its own width.
+	Width int
+	// Align is left, center or right if Bear aligns the image.
+	Align string
+
+	// The size of images made by the image pipeline, and a srcset of all their widths.
+	ImageWidth  int
+	ImageHeight int
+	Srcset      string
+}
+
+// setMeta reads the settings Bear 2 keeps in a comment after an image, such as <!-- {"width":300} -->.
+// Widths that aren't numbers, like "fill", leave the image at its own width.
+func (f *figure) setMeta(comment []byte) {
+	c := bytes.TrimSpace(comment)
+	c = bytes.TrimSpace(bytes.TrimSuffix(bytes.TrimPrefix(c, []byte("<!--")), []byte("-->")))
+	if len(c) == 0 {
+		return
+	}
+
+	var meta map[string]interface{}
+	if err := json.Unmarshal(c, &meta); err != nil {
+		log.Warnf("Ignoring image settings %s: %s", c, err)
+		return
+	}
+
+	if w, ok := meta["width"].(float64); ok && w > 0 {
+		f.Width = int(w)
+	}
+	if a, ok := meta["alt"].(string); ok && f.Alt == "" {
+		f.Alt = a
+	}
+	for _, k := range []string{"alignment", "align"} {
+		if a, ok := meta[k].(string); ok {
+			f.Align = strings.ToLower(a)
+		}
+	}
+}
+
+// altText is the figure's alt text, falling back on its caption.
+func (f figure) altText() string {
+	if f.Alt != "" {
+		return f.Alt
+	}
+
+	return f.Caption
+}
+
+// imageWriter writes the images in a note the way the route is configured to.
+type imageWriter struct {
+	// Directory images are linked from, relative to the note if it's empty.
+	dir    string
+	markup string
+	tmpl   *template.Template
+	// Images the image pipeline made, by name.
+	processed map[string]processedImage
+	// Whether Bear 1 images are linked in the folders named by their ID
+	// that they were copied into.
+	folders bool
+}
+
+// captions reports whether the caption is part of the written image, so the
+// caption line under it isn't needed.
+func (w imageWriter) captions() bool {
+	return w.tmpl != nil || w.markup == imageFigure
+}
+
+// link is the link to an image Bear refers to by src, leaving images on the web where they are.
+func (w imageWriter) link(src string) string {
+	if strings.Contains(src, "://") {
+		return src
+	}
+
+	return linkPath(w.dir, path.Base(src))
+}
+
+// write writes a figure whose Src is already linked to.
+func (w imageWriter) write(f figure) []byte {
+	if name, err := url.PathUnescape(strings.TrimPrefix(f.Src, w.dir+"/")); err == nil && !strings.Contains(f.Src, "://") {
+		if pi, ok := w.processed[name]; ok {
+			// The smaller copies are beside the full size image.
+			dir := strings.TrimSuffix(f.Src, path.Base(f.Src))
+			srcset := []string{}
+			for _, v := range pi.Variants {
+				srcset = append(srcset, fmt.Sprintf("%s%s %dw", dir, url.PathEscape(path.Base(v.Name)), v.Width))
+			}
+			srcset = append(srcset, fmt.Sprintf("%s %dw", f.Src, pi.Width))
+
+			f.ImageWidth, f.ImageHeight = pi.Width, pi.Height
+			f.Srcset = strings.Join(srcset, ", ")
+		}
+	}
+
+	if w.tmpl != nil {
+		b := &bytes.Buffer{}
+		err := w.tmpl.Execute(b, f)
+		if err == nil {
+			return b.Bytes()
+		}
+		log.Errorf("Writing image %s: %s", f.Src, err)
+	}
+
+	switch w.markup {
+	case imageFigure:
+		fig := fmt.Sprintf("{{< figure src=%s", strconv.Quote(f.Src))
+		if alt := f.altText(); alt != "" {
+			fig += fmt.Sprintf(" alt=%s", strconv.Quote(alt))
+		}
+		if f.Caption != "" {
+			fig += fmt.Sprintf(" caption=%s", strconv.Quote(f.Caption))
+		}
+		switch {
+		case f.Width > 0:
+			fig += fmt.Sprintf(` width="%d"`, f.Width)
+		case f.ImageWidth > 0:
+			fig += fmt.Sprintf(` width="%d" height="%d"`, f.ImageWidth, f.ImageHeight)
+		}
+		if f.Align != "" {
+			fig += fmt.Sprintf(` class="align-%s"`, f.Align)
+		}
+		return []byte(fig + " >}}")
+
+	case imageSrcset:
+		img := fmt.Sprintf(`<img src="%s"`, html.EscapeString(f.Src))
+		if f.Srcset != "" {
+			img += fmt.Sprintf(` srcset="%s" sizes="(max-width: %dpx) 100vw, %dpx"`, html.EscapeString(f.Srcset), f.ImageWidth, f.ImageWidth)
+		}
+		img += fmt.Sprintf(` alt="%s"`, html.EscapeString(f.altText()))
+		switch {
+		case f.Width > 0:
+			img += fmt.Sprintf(` width="%d"`, f.Width)
+		case f.ImageWidth > 0:
+			img += fmt.Sprintf(` width="%d" height="%d"`, f.ImageWidth, f.ImageHeight)
+		}
+		if f.Align != "" {
+			img += fmt.Sprintf(` class="align-%s"`, f.Align)
+		}
+		return []byte(img + ">")
+	}
+
+	return []byte(fmt.Sprintf("![%s](%s)", f.altText(), f.Src))
+}
+
+// caption finds the caption under an image on line i, which is assumed to be italics or bold.
+func caption(d document, i int) string {
+	if i+1 >= len(d.lines) || d.code[i+1] || !bytes.HasPrefix(d.lines[i+1], []byte("*")) {
+		return ""
+	}
+
+	return string(bytes.Trim(d.lines[i+1], "*"))
+}
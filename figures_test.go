@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFigureSetMeta(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		exp     figure
+	}{
+		{"none", "", figure{Alt: "Cat"}},
+		{"width", `<!-- {"width":300} -->`, figure{Alt: "Cat", Width: 300}},
+		{"fill", ` <!-- {"width":"fill"} -->`, figure{Alt: "Cat"}},
+		{"alignment", `<!-- {"width":120,"alignment":"Center","alt":"Not the alt"} -->`, figure{Alt: "Cat", Width: 120, Align: "center"}},
+		{"invalid", `<!-- not json -->`, figure{Alt: "Cat"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := figure{Alt: "Cat"}
+			f.setMeta([]byte(test.comment))
+			require.Equal(t, test.exp, f)
+		})
+	}
+}
+
+func TestImageWriter(t *testing.T) {
+	tmpl, err := template.New("image.md").Delims("[[", "]]").ParseFiles("testData/templates/image.md")
+	require.NoError(t, err)
+
+	processed := map[string]processedImage{
+		"my photo.jpg": {Name: "my photo.jpg", Width: 960, Height: 720, Variants: []imageVariant{{Name: "my photo-480w.jpg", Width: 480}}},
+	}
+	text := []string{
+		`![](my%20photo.jpg)<!-- {"width":300,"alignment":"center"} -->`,
+		`*A "view"*`,
+		"![Logo](logo.png) ![Web](https://example.com/web.png)",
+		"`![](my%20photo.jpg)`",
+	}
+
+	tests := []struct {
+		name string
+		w    imageWriter
+		exp  []string
+	}{
+		{
+			"markdown",
+			imageWriter{dir: "/img/posts", processed: processed},
+			[]string{
+				`![A "view"](/img/posts/my%20photo.jpg)`,
+				`*A "view"*`,
+				"![Logo](/img/posts/logo.png) ![Web](https://example.com/web.png)",
+				"`![](my%20photo.jpg)`",
+			},
+		},
+		{
+			"figure",
+			imageWriter{dir: "/img/posts", markup: imageFigure, processed: processed},
+			[]string{
+				`{{< figure src="/img/posts/my%20photo.jpg" alt="A \"view\"" caption="A \"view\"" width="300" class="align-center" >}}`,
+				"",
+				`{{< figure src="/img/posts/logo.png" alt="Logo" >}} {{< figure src="https://example.com/web.png" alt="Web" >}}`,
+				"`![](my%20photo.jpg)`",
+			},
+		},
+		{
+			"srcset",
+			imageWriter{dir: "/img/posts", markup: imageSrcset, processed: processed},
+			[]string{
+				`<img src="/img/posts/my%20photo.jpg" srcset="/img/posts/my%20photo-480w.jpg 480w, /img/posts/my%20photo.jpg 960w" sizes="(max-width: 960px) 100vw, 960px" alt="A &#34;view&#34;" width="300" class="align-center">`,
+				`*A "view"*`,
+				`<img src="/img/posts/logo.png" alt="Logo"> <img src="https://example.com/web.png" alt="Web">`,
+				"`![](my%20photo.jpg)`",
+			},
+		},
+		{
+			"template",
+			imageWriter{markup: imageFigure, tmpl: tmpl},
+			[]string{
+				`{{< img src="my%20photo.jpg" alt="" caption="A "view"" width="300" >}}`,
+				"",
+				`{{< img src="logo.png" alt="Logo" >}} {{< img src="https://example.com/web.png" alt="Web" >}}`,
+				"`![](my%20photo.jpg)`",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := parseDocument(bytes.Split([]byte(strings.Join(text, "\n")), []byte("\n")))
+			parseImagesBear2(d, test.w)
+			require.Equal(t, strings.Join(test.exp, "\n"), string(d.bytes()))
+		})
+	}
+}
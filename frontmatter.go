@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Front matter formats that Hugo understands.
+const (
+	formatYAML = "yaml"
+	formatTOML = "toml"
+	formatJSON = "json"
+)
+
+// frontMatterDoc is front matter as the ordered list of its top level entries.
+// Each entry keeps its text exactly as it was written, so anything Bhugo
+// doesn't manage can be written back untouched.
+type frontMatterDoc struct {
+	format  string
+	entries []frontMatterEntry
+}
+
+// frontMatterEntry is a top level key with its value, which can carry on over
+// the lines after it. Comments and blank lines between keys are entries without
+// a key, as are TOML tables since Bhugo doesn't manage anything in them.
+type frontMatterEntry struct {
+	key   string
+	value []byte
+	lines [][]byte
+}
+
+// frontMatterDelims are the lines YAML and TOML front matter is written between.
+var frontMatterDelims = map[string]string{
+	formatYAML: "---",
+	formatTOML: "+++",
+}
+
+// readFrontMatter parses the front matter at the start of a post, returning
+// the rest of the post after it. It reports whether the post has front matter.
+func readFrontMatter(f []byte) (frontMatterDoc, []byte, bool) {
+	if bytes.HasPrefix(f, []byte("{")) {
+		return readJSONFrontMatter(f)
+	}
+
+	lines := bytes.Split(f, []byte("\n"))
+	for format, delim := range frontMatterDelims {
+		if !bytes.Equal(lines[0], []byte(delim)) {
+			continue
+		}
+
+		for i, l := range lines[1:] {
+			if !bytes.Equal(l, []byte(delim)) {
+				continue
+			}
+
+			rest := []byte{}
+			if len(lines) > i+2 {
+				rest = append([]byte("\n"), bytes.Join(lines[i+2:], []byte("\n"))...)
+			}
+			if format == formatTOML {
+				return parseTOMLFrontMatter(lines[1 : i+1]), rest, true
+			}
+			return parseFrontMatter(lines[1 : i+1]), rest, true
+		}
+	}
+
+	// Should not reach this if file is formatted correctly.
+	return frontMatterDoc{}, nil, false
+}
+
+// parseFrontMatter splits the lines of YAML front matter into its entries.
+func parseFrontMatter(lines [][]byte) frontMatterDoc {
+	d := frontMatterDoc{format: formatYAML}
+	for i, l := range lines {
+		last := len(d.entries) - 1
+		if key, value, ok := frontMatterKey(l); ok {
+			d.entries = append(d.entries, frontMatterEntry{key: key, value: value, lines: [][]byte{l}})
+		} else if last >= 0 && d.entries[last].key != "" && continues(lines[i:]) {
+			d.entries[last].lines = append(d.entries[last].lines, l)
+		} else {
+			d.entries = append(d.entries, frontMatterEntry{lines: [][]byte{l}})
+		}
+	}
+
+	return d
+}
+
+// frontMatterKey returns the key and the rest of the line if it starts a top level YAML entry.
+func frontMatterKey(l []byte) (string, []byte, bool) {
+	if len(l) == 0 || l[0] == ' ' || l[0] == '\t' || l[0] == '#' || l[0] == '-' {
+		return "", nil, false
+	}
+
+	// A quoted key can have anything in it.
+	start := 0
+	if l[0] == '"' || l[0] == '\'' {
+		end := bytes.IndexByte(l[1:], l[0])
+		if end < 0 {
+			return "", nil, false
+		}
+		start = end + 2
+	}
+
+	for i := start; i < len(l); i++ {
+		switch {
+		case l[i] == ':' && (i+1 == len(l) || l[i+1] == ' ' || l[i+1] == '\t'):
+			key := strings.TrimSpace(string(l[:i]))
+			if start > 0 {
+				key = key[1 : start-1]
+			}
+			return key, bytes.TrimSpace(l[i+1:]), true
+
+		// The rest of the line is a comment.
+		case l[i] == '#' && (l[i-1] == ' ' || l[i-1] == '\t'):
+			return "", nil, false
+		}
+	}
+
+	return "", nil, false
+}
+
+// continues reports whether the first of lines carries on the value of the YAML
+// entry before it. Blank lines and comments do if the next line of the value does.
+func continues(lines [][]byte) bool {
+	for _, l := range lines {
+		if len(bytes.TrimSpace(l)) == 0 || l[0] == '#' {
+			continue
+		}
+
+		_, _, key := frontMatterKey(l)
+		return !key
+	}
+
+	return false
+}
+
+// tomlKey matches a TOML key and value, capturing the key and the value.
+var tomlKey = regexp.MustCompile(`^\s*("(?:[^"\\]|\\.)*"|'[^']*'|[A-Za-z0-9_-]+(?:\s*\.\s*(?:[A-Za-z0-9_-]+|"[^"]*"|'[^']*'))*)\s*=\s*(.*)$`)
+
+// parseTOMLFrontMatter splits the lines of TOML front matter into its entries.
+func parseTOMLFrontMatter(lines [][]byte) frontMatterDoc {
+	d := frontMatterDoc{format: formatTOML}
+	table := false
+	for _, l := range lines {
+		last := len(d.entries) - 1
+		trimmed := bytes.TrimSpace(l)
+
+		switch sm := tomlKey.FindSubmatch(l); {
+		// Arrays, inline tables and multi-line strings can carry on over lines.
+		case last >= 0 && tomlOpen(bytes.Join(d.entries[last].lines, []byte("\n"))):
+			d.entries[last].lines = append(d.entries[last].lines, l)
+
+		// Keys after a table header belong to the table, which goes on to the next header.
+		case bytes.HasPrefix(trimmed, []byte("[")):
+			table = true
+			d.entries = append(d.entries, frontMatterEntry{lines: [][]byte{l}})
+		case table:
+			d.entries[last].lines = append(d.entries[last].lines, l)
+
+		case sm != nil:
+			key := string(sm[1])
+			if strings.HasPrefix(key, `"`) || strings.HasPrefix(key, "'") {
+				key = key[1 : len(key)-1]
+			}
+			d.entries = append(d.entries, frontMatterEntry{key: key, value: sm[2], lines: [][]byte{l}})
+		default:
+			d.entries = append(d.entries, frontMatterEntry{lines: [][]byte{l}})
+		}
+	}
+
+	return d
+}
+
+// tomlOpen reports whether TOML ends in the middle of a value, in a multi-line
+// string or with brackets or braces that haven't been closed.
+func tomlOpen(b []byte) bool {
+	depth := 0
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case bytes.HasPrefix(b[i:], []byte(`"""`)) || bytes.HasPrefix(b[i:], []byte(`'''`)):
+			end := bytes.Index(b[i+3:], b[i:i+3])
+			if end < 0 {
+				return true
+			}
+			i += 3 + end + 2
+		case c == '"' || c == '\'':
+			for i++; i < len(b) && b[i] != c && b[i] != '\n'; i++ {
+				if c == '"' && b[i] == '\\' {
+					i++
+				}
+			}
+		case c == '#':
+			for i < len(b) && b[i] != '\n' {
+				i++
+			}
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+
+	return depth > 0
+}
+
+// readJSONFrontMatter parses the members of the JSON object at the start of a post.
+func readJSONFrontMatter(f []byte) (frontMatterDoc, []byte, bool) {
+	d := frontMatterDoc{format: formatJSON}
+
+	i := 1
+	for {
+		i += len(f[i:]) - len(bytes.TrimLeft(f[i:], " \t\r\n,"))
+		if i >= len(f) {
+			return frontMatterDoc{}, nil, false
+		}
+		if f[i] == '}' {
+			return d, f[i+1:], true
+		}
+
+		// Members are a string key, a colon and the value.
+		colon := jsonStringEnd(f, i)
+		var key string
+		if colon < 0 || json.Unmarshal(f[i:colon], &key) != nil {
+			return frontMatterDoc{}, nil, false
+		}
+		colon += len(f[colon:]) - len(bytes.TrimLeft(f[colon:], " \t\r\n"))
+		if colon >= len(f) || f[colon] != ':' {
+			return frontMatterDoc{}, nil, false
+		}
+
+		end := jsonValueEnd(f, colon+1)
+		if end < 0 {
+			return frontMatterDoc{}, nil, false
+		}
+		m := bytes.TrimSpace(f[i:end])
+		value := bytes.TrimSpace(f[colon+1 : end])
+
+		d.entries = append(d.entries, frontMatterEntry{key: key, value: value, lines: [][]byte{m}})
+		i = end
+	}
+}
+
+// jsonStringEnd finds the end of the JSON string starting at i.
+func jsonStringEnd(b []byte, i int) int {
+	if i >= len(b) || b[i] != '"' {
+		return -1
+	}
+
+	for i++; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+// jsonValueEnd finds where the JSON value starting at i stops, at a comma or
+// closing brace that isn't nested or in a string.
+func jsonValueEnd(b []byte, i int) int {
+	depth := 0
+	for ; i < len(b); i++ {
+		switch b[i] {
+		case '"':
+			end := jsonStringEnd(b, i)
+			if end < 0 {
+				return -1
+			}
+			i = end - 1
+		case '[', '{':
+			depth++
+		case ']', '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// value returns the raw value of a key, if it's there. Only the part on the
+// same line as the key is returned, and JSON strings are unquoted.
+func (d frontMatterDoc) value(key string) string {
+	for _, e := range d.entries {
+		if e.key != key {
+			continue
+		}
+
+		var s string
+		if d.format == formatJSON && json.Unmarshal(e.value, &s) == nil {
+			return s
+		}
+		return string(e.value)
+	}
+
+	return ""
+}
+
+// custom returns the lines of all the entries that Bhugo doesn't manage, in order.
+// JSON members are returned whole, since they're written with commas between them.
+func (d frontMatterDoc) custom(managed map[string]bool) []string {
+	lines := []string{}
+	for _, e := range d.entries {
+		if managed[e.key] {
+			continue
+		}
+		for _, l := range e.lines {
+			lines = append(lines, string(l))
+		}
+	}
+
+	return lines
+}
+
+// convert writes the entries Bhugo doesn't manage in another format, in order, for a post
+// whose front matter format has changed, along with its date. The front matter is read with
+// a decoder for its own format, so strings are unquoted by its rules, but comments are lost.
+func (d frontMatterDoc) convert(format string, managed map[string]bool) ([]string, string, error) {
+	values, err := d.decode()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Dates that aren't strings are written the same way in each format.
+	date := d.value("date")
+	if s, ok := values["date"].(string); ok {
+		date = s
+	}
+
+	// TOML tables have no key in the entries, so they come after the keys that do.
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, e := range d.entries {
+		if _, ok := values[e.key]; ok && !seen[e.key] {
+			keys = append(keys, e.key)
+			seen[e.key] = true
+		}
+	}
+	rest := []string{}
+	for k := range values {
+		if !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+
+	lines, tables := []string{}, []string{}
+	for _, k := range append(keys, rest...) {
+		if managed[k] {
+			continue
+		}
+
+		e, err := encodeEntry(format, k, values[k])
+		if err != nil {
+			return nil, "", err
+		}
+		switch {
+		case format == formatJSON:
+			lines = append(lines, e)
+		case strings.HasPrefix(e, "["):
+			tables = append(tables, strings.Split(e, "\n")...)
+		case e != "":
+			lines = append(lines, strings.Split(e, "\n")...)
+		}
+	}
+
+	return append(lines, tables...), date, nil
+}
+
+// decode reads the values of the front matter with a decoder for its format.
+func (d frontMatterDoc) decode() (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	switch d.format {
+	case formatJSON:
+		dec := json.NewDecoder(bytes.NewReader(d.bytes()))
+		dec.UseNumber()
+		if err := dec.Decode(&values); err != nil {
+			return nil, err
+		}
+		return jsonNumbers(values).(map[string]interface{}), nil
+	case formatTOML:
+		_, err := toml.Decode(string(d.body()), &values)
+		return values, err
+	default:
+		return values, yaml.Unmarshal(d.body(), &values)
+	}
+}
+
+// jsonNumbers replaces the numbers in decoded JSON with integers where they're whole,
+// so they aren't written as floats or strings in other formats.
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = jsonNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonNumbers(e)
+		}
+	}
+
+	return v
+}
+
+// encodeEntry writes a key and its value in a front matter format. TOML has no null,
+// so keys without a value are left out of it.
+func encodeEntry(format, key string, value interface{}) (string, error) {
+	b := &bytes.Buffer{}
+	switch format {
+	case formatJSON:
+		k, err := jsonValue(key)
+		if err != nil {
+			return "", err
+		}
+		v, err := jsonValue(value)
+		return k + ": " + v, err
+	case formatTOML:
+		if err := toml.NewEncoder(b).Encode(map[string]interface{}{key: value}); err != nil {
+			return "", err
+		}
+	default:
+		e := yaml.NewEncoder(b)
+		e.SetIndent(2)
+		if err := e.Encode(map[string]interface{}{key: value}); err != nil {
+			return "", err
+		}
+	}
+
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// jsonValue writes v as JSON, leaving the characters HTML uses as they are.
+func jsonValue(v interface{}) (string, error) {
+	b := &bytes.Buffer{}
+	e := json.NewEncoder(b)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// set replaces the entry for a key with its value, adding it at the end if the key isn't there.
+// The value is written as it is, so it must already be in the document's format.
+func (d *frontMatterDoc) set(key, value string) {
+	var line string
+	switch d.format {
+	case formatTOML:
+		line = fmt.Sprintf("%s = %s", key, value)
+	case formatJSON:
+		line = fmt.Sprintf("%q: %s", key, value)
+	default:
+		line = fmt.Sprintf("%s: %s", key, value)
+	}
+
+	d.setEntry(frontMatterEntry{key: key, value: []byte(value), lines: [][]byte{[]byte(line)}})
+}
+
+// setEntry replaces the entry with the same key, adding it at the end if the key isn't there.
+func (d *frontMatterDoc) setEntry(entry frontMatterEntry) {
+	for i, e := range d.entries {
+		if e.key == entry.key {
+			d.entries[i] = entry
+			return
+		}
+	}
+
+	// TOML keys after a table would belong to it, so new keys go before any tables.
+	at := len(d.entries)
+	if d.format == formatTOML {
+		for i, e := range d.entries {
+			if e.key == "" && bytes.HasPrefix(bytes.TrimSpace(e.lines[0]), []byte("[")) {
+				at = i
+				break
+			}
+		}
+
+		// Leave blank lines and comments before the table with it.
+		for at > 0 && at < len(d.entries) && d.entries[at-1].key == "" {
+			at--
+		}
+	}
+	d.entries = append(d.entries[:at], append([]frontMatterEntry{entry}, d.entries[at:]...)...)
+}
+
+// merge updates the front matter with the keys of newly written front matter in the
+// same format. Keys are updated where they are and new keys are added, so the keys and
+// comments around them stay where they were. Managed keys that weren't written are dropped.
+func (d *frontMatterDoc) merge(written frontMatterDoc, managed map[string]bool) {
+	keys := map[string]bool{}
+	for _, e := range written.entries {
+		if e.key == "" {
+			continue
+		}
+		keys[e.key] = true
+		d.setEntry(e)
+	}
+
+	entries := []frontMatterEntry{}
+	for _, e := range d.entries {
+		if managed[e.key] && !keys[e.key] {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	d.entries = entries
+}
+
+// body writes the lines of YAML or TOML front matter, without its delimiters.
+func (d frontMatterDoc) body() []byte {
+	lines := [][]byte{}
+	for _, e := range d.entries {
+		lines = append(lines, e.lines...)
+	}
+
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// bytes writes the front matter, with its delimiters.
+func (d frontMatterDoc) bytes() []byte {
+	lines := [][]byte{}
+	for _, e := range d.entries {
+		lines = append(lines, e.lines...)
+	}
+
+	if d.format == formatJSON {
+		return append(append([]byte("{\n  "), bytes.Join(lines, []byte(",\n  "))...), "\n}"...)
+	}
+
+	delim := []byte(frontMatterDelims[d.format])
+	lines = append(append([][]byte{delim}, lines...), delim)
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// siteFrontMatterFormat works out the front matter format a Hugo site uses from its
+// default archetype, which is what hugo new writes, or else its configuration file.
+func siteFrontMatterFormat(hugoDir string) string {
+	if b, err := ioutil.ReadFile(filepath.Join(hugoDir, "archetypes", "default.md")); err == nil {
+		if d, _, ok := readFrontMatter(b); ok {
+			return d.format
+		}
+	}
+
+	for _, name := range []string{"hugo", "config"} {
+		for _, c := range []struct{ ext, format string }{{".toml", formatTOML}, {".yaml", formatYAML}, {".yml", formatYAML}, {".json", formatJSON}} {
+			if _, err := os.Stat(filepath.Join(hugoDir, name+c.ext)); err == nil {
+				return c.format
+			}
+		}
+	}
+
+	return formatYAML
+}
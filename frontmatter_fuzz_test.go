@@ -0,0 +1,78 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// fuzzFrontMatter is the front matter the fuzz test reads back.
+type fuzzFrontMatter struct {
+	Title      string   `yaml:"title" toml:"title" json:"title"`
+	Categories []string `yaml:"categories" toml:"categories" json:"categories"`
+	Draft      bool     `yaml:"draft" toml:"draft" json:"draft"`
+}
+
+// FuzzFrontMatterTitle checks that any title, and a tag of the same text, reads back
+// from the front matter of each format, read with a decoder for the format.
+func FuzzFrontMatterTitle(f *testing.F) {
+	for _, s := range []string{"Post", `Say "hi"`, `C:\Users`, "# Not a comment", "key: value", "[[table]]", "{braces}", "'single'", "Tab\tand\nnewline", "日本語 🎌", "Line\u2028separator", "\x7f\u0085\ufeff"} {
+		f.Add(s)
+	}
+
+	tmpl := template.Must(template.New("Note Template").Funcs(templateFuncs).Parse(templateRaw))
+	r := route{tmpl: tmpl}
+
+	f.Fuzz(func(t *testing.T, title string) {
+		// Bear only stores text, so titles are always valid UTF-8.
+		if !utf8.ValidString(title) {
+			t.Skip()
+		}
+
+		for _, format := range []string{formatYAML, formatTOML, formatJSON} {
+			n := note{Title: title, Date: "2019-04-29T07:55:21-07:00", LastMod: "2019-04-29T07:55:21-07:00", Hashtags: []string{title}, Categories: true, Body: "Body"}
+
+			b := &bytes.Buffer{}
+			if err := r.template(format).Execute(b, n); err != nil {
+				t.Fatal(err)
+			}
+
+			d, rest, ok := readFrontMatter(b.Bytes())
+			if !ok || string(rest) != "\nBody" {
+				t.Fatalf("%s front matter not found in %q", format, b.String())
+			}
+
+			var fm fuzzFrontMatter
+			var err error
+			switch format {
+			case formatYAML:
+				err = yaml.Unmarshal(d.body(), &fm)
+			case formatTOML:
+				_, err = toml.Decode(string(d.body()), &fm)
+			case formatJSON:
+				err = json.Unmarshal(bytes.TrimSuffix(b.Bytes(), rest), &fm)
+			}
+			if err != nil {
+				t.Fatalf("%s front matter %q: %s", format, b.String(), err)
+			}
+
+			if fm.Title != title {
+				t.Fatalf("%s title %q read back as %q", format, title, fm.Title)
+			}
+			if len(fm.Categories) != 1 || fm.Categories[0] != title {
+				t.Fatalf("%s categories %q read back as %q", format, title, fm.Categories)
+			}
+			if fm.Draft {
+				t.Fatalf("%s draft read back as true", format)
+			}
+		}
+	})
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	f := []byte(`---
+# Written by hand
+title: "Existing"
+"quoted: key": 1
+description: |
+  A long description
+
+  over two paragraphs.
+tags:
+- custom-tag
+- another
+params:
+  tags: [not, bhugo's]
+  # Nested comment
+  date: 2001-01-01
+draft: false # not yet
+aliases: [
+  /old,
+  /older
+]
+
+url:
+  /posts/existing/
+categories:
+  - blog
+---
+
+date: not front matter`)
+
+	d, rest, ok := readFrontMatter(f)
+	require.True(t, ok)
+	require.Equal(t, formatYAML, d.format)
+	require.Equal(t, "\n\ndate: not front matter", string(rest))
+	keys := []string{}
+	for _, e := range d.entries {
+		keys = append(keys, e.key)
+	}
+	require.Equal(t, []string{"", "title", "quoted: key", "description", "tags", "params", "draft", "aliases", "", "url", "categories"}, keys)
+	require.Equal(t, string(f), string(append(d.bytes(), rest...)))
+
+	require.Equal(t, `"Existing"`, d.value("title"))
+	require.Equal(t, "1", d.value("quoted: key"))
+	require.Equal(t, "false # not yet", d.value("draft"))
+	require.Equal(t, "", d.value("date"))
+
+	require.Equal(t, []string{
+		"# Written by hand",
+		`"quoted: key": 1`,
+		"description: |",
+		"  A long description",
+		"",
+		"  over two paragraphs.",
+		"params:",
+		"  tags: [not, bhugo's]",
+		"  # Nested comment",
+		"  date: 2001-01-01",
+		"aliases: [",
+		"  /old,",
+		"  /older",
+		"]",
+		"",
+		"url:",
+		"  /posts/existing/",
+	}, d.custom(bhugoFrontMatter))
+
+	d.set("draft", "true")
+	d.set("lastmod", "today")
+	require.Equal(t, "true", d.value("draft"))
+	require.Equal(t, "today", d.value("lastmod"))
+	require.Contains(t, string(d.bytes()), "params:\n  tags: [not, bhugo's]\n  # Nested comment\n  date: 2001-01-01\ndraft: true\naliases: [")
+	require.True(t, strings.HasSuffix(string(d.bytes()), "categories:\n  - blog\nlastmod: today\n---"))
+}
+
+func TestFrontMatterKey(t *testing.T) {
+	tests := []struct {
+		line  string
+		key   string
+		value string
+		ok    bool
+	}{
+		{"title: Post", "title", "Post", true},
+		{"draft:", "draft", "", true},
+		{"'a: b' : c", "a: b", "c", true},
+		{"url: http://example.com", "url", "http://example.com", true},
+		{"  nested: value", "", "", false},
+		{"- item", "", "", false},
+		{"# comment: here", "", "", false},
+		{"text # comment: here", "", "", false},
+		{"http://example.com", "", "", false},
+		{"]", "", "", false},
+		{"", "", "", false},
+	}
+
+	for _, test := range tests {
+		key, value, ok := frontMatterKey([]byte(test.line))
+		require.Equal(t, test.ok, ok, test.line)
+		require.Equal(t, test.key, key, test.line)
+		require.Equal(t, test.value, string(value), test.line)
+	}
+}
+
+func TestParseTOMLFrontMatter(t *testing.T) {
+	f := []byte(`+++
+# Written by hand
+title = "Existing"
+"draft" = false
+date = 2019-04-29T07:55:21-07:00
+description = """
+tags = ["not", "a", "key"]
+"""
+aliases = [
+  "/old",
+  "/older", # draft = true
+]
+site.params = { tags = ["nested"] }
+
+[params]
+tags = ["in", "a", "table"]
+
+[[menu.main]]
+name = "Existing"
++++
+
+Body`)
+
+	d, rest, ok := readFrontMatter(f)
+	require.True(t, ok)
+	require.Equal(t, formatTOML, d.format)
+	require.Equal(t, "\n\nBody", string(rest))
+	require.Equal(t, string(f), string(append(d.bytes(), rest...)))
+
+	keys := []string{}
+	for _, e := range d.entries {
+		keys = append(keys, e.key)
+	}
+	require.Equal(t, []string{"", "title", "draft", "date", "description", "aliases", "site.params", "", "", ""}, keys)
+	require.Equal(t, "2019-04-29T07:55:21-07:00", d.value("date"))
+
+	require.Equal(t, []string{
+		"# Written by hand",
+		`description = """`,
+		`tags = ["not", "a", "key"]`,
+		`"""`,
+		"aliases = [",
+		`  "/old",`,
+		`  "/older", # draft = true`,
+		"]",
+		`site.params = { tags = ["nested"] }`,
+		"",
+		"[params]",
+		`tags = ["in", "a", "table"]`,
+		"",
+		"[[menu.main]]",
+		`name = "Existing"`,
+	}, d.custom(bhugoFrontMatter))
+
+	// New keys go before the tables they'd otherwise belong to.
+	d.set("draft", "true")
+	d.set("lastmod", "2020-01-01")
+	require.Contains(t, string(d.bytes()), "title = \"Existing\"\ndraft = true\ndate =")
+	require.Contains(t, string(d.bytes()), "site.params = { tags = [\"nested\"] }\nlastmod = 2020-01-01\n\n[params]")
+}
+
+func TestParseJSONFrontMatter(t *testing.T) {
+	f := []byte(`{
+   "title": "Existing \"post\"",
+   "date": "2019-04-29T07:55:21-07:00",
+   "params": {"draft": true, "list": [1, 2, "}"]},
+   "aliases": [
+      "/old"
+   ]
+}
+
+Body`)
+
+	d, rest, ok := readFrontMatter(f)
+	require.True(t, ok)
+	require.Equal(t, formatJSON, d.format)
+	require.Equal(t, "\n\nBody", string(rest))
+	require.Equal(t, `Existing "post"`, d.value("title"))
+	require.Equal(t, "2019-04-29T07:55:21-07:00", d.value("date"))
+	require.Equal(t, []string{
+		`"params": {"draft": true, "list": [1, 2, "}"]}`,
+		"\"aliases\": [\n      \"/old\"\n   ]",
+	}, d.custom(bhugoFrontMatter))
+
+	d.set("draft", "true")
+	require.Equal(t, `{
+  "title": "Existing \"post\"",
+  "date": "2019-04-29T07:55:21-07:00",
+  "params": {"draft": true, "list": [1, 2, "}"]},
+  "aliases": [
+      "/old"
+   ],
+  "draft": true
+}`, string(d.bytes()))
+
+	for _, invalid := range []string{"{", `{"title" "Post"}`, `{"title": "Post"`, `{title: "Post"}`} {
+		_, _, ok := readFrontMatter([]byte(invalid))
+		require.False(t, ok, invalid)
+	}
+}
+
+func TestConvertFrontMatter(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		format string
+		exp    []string
+		date   string
+	}{
+		{
+			"yaml to toml",
+			"---\ntitle: Post\ndate: '2019-04-29'\nparams:\n  list: [a, b]\nauthor: \"Me\\tYou\"\nempty:\n---\n",
+			formatTOML,
+			[]string{`author = "Me\tYou"`, "[params]", `  list = ["a", "b"]`},
+			"2019-04-29",
+		},
+		{
+			"toml to yaml",
+			"+++\ndate = 2019-04-29T07:55:21-07:00\nweight = 3\n[params]\nsub = 'x'\n+++\n",
+			formatYAML,
+			[]string{"weight: 3", "params:", "  sub: x"},
+			"2019-04-29T07:55:21-07:00",
+		},
+		{
+			"json to toml",
+			`{"date": "2019-04-29", "weight": 3, "ratio": 1.5, "html": "<b>"}`,
+			formatTOML,
+			[]string{"weight = 3", "ratio = 1.5", `html = "<b>"`},
+			"2019-04-29",
+		},
+		{
+			"yaml to json",
+			"---\nhtml: <b>&</b>\nnested:\n  a: 1\n---\n",
+			formatJSON,
+			[]string{`"html": "<b>&</b>"`, `"nested": {"a":1}`},
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, _, ok := readFrontMatter([]byte(test.in))
+			require.True(t, ok)
+			custom, date, err := d.convert(test.format, bhugoFrontMatter)
+			require.NoError(t, err)
+			require.Equal(t, test.exp, custom)
+			require.Equal(t, test.date, date)
+		})
+	}
+
+	d, _, ok := readFrontMatter([]byte("---\ntitle: [unclosed\n---\n"))
+	require.True(t, ok)
+	_, _, err := d.convert(formatJSON, bhugoFrontMatter)
+	require.Error(t, err)
+}
+
+func TestSiteFrontMatterFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.Equal(t, formatYAML, siteFrontMatterFormat(dir))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.toml"), []byte(`baseURL = "/"`), 0644))
+	require.Equal(t, formatTOML, siteFrontMatterFormat(dir))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "archetypes"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "archetypes", "default.md"), []byte("{\n  \"draft\": true\n}\n"), 0644))
+	require.Equal(t, formatJSON, siteFrontMatterFormat(dir))
+}
+
+func TestFrontMatterTemplates(t *testing.T) {
+	custom := map[string][]string{
+		formatYAML: {"custom: abc"},
+		formatTOML: {`custom = "abc"`},
+		formatJSON: {`"custom": "abc"`},
+	}
+
+	for _, format := range []string{formatYAML, formatTOML, formatJSON} {
+		t.Run(format, func(t *testing.T) {
+			tmpl, err := template.New("Note Template").Funcs(templateFuncs).Parse(templateRaw)
+			require.NoError(t, err)
+
+			r := route{tmpl: tmpl}
+			n := note{Title: "Post", Date: "2019-04-29T07:55:21-07:00", LastMod: "2019-04-30T07:55:21-07:00", Hashtags: []string{"A", "B"}, Categories: true, CustomFrontMatter: custom[format], Body: "Body"}
+
+			b := &bytes.Buffer{}
+			require.NoError(t, r.template(format).Execute(b, n))
+
+			d, rest, ok := readFrontMatter(b.Bytes())
+			require.True(t, ok, b.String())
+			require.Equal(t, format, d.format)
+			require.Equal(t, "\nBody", string(rest))
+			require.Equal(t, "2019-04-29T07:55:21-07:00", d.value("date"))
+			require.Equal(t, "false", d.value("draft"))
+			require.Equal(t, custom[format], d.custom(bhugoFrontMatter))
+
+			if format == formatJSON {
+				require.True(t, json.Valid(bytes.TrimSuffix(b.Bytes(), rest)))
+			}
+		})
+	}
+}
@@ -3,11 +3,15 @@ module github.com/Zach-Johnson/bhugo
 go 1.13
 
 require (
+	github.com/BurntSushi/toml v1.2.1
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/jmoiron/sqlx v1.2.0
 	github.com/joho/godotenv v1.3.0
 	github.com/kelseyhightower/envconfig v1.3.0
 	github.com/mattn/go-sqlite3 v1.10.0
 	github.com/sirupsen/logrus v1.4.1
 	github.com/stretchr/testify v1.3.0
+	golang.org/x/text v0.3.8
 	google.golang.org/appengine v1.5.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// imagePipeline is how images are processed as they're copied into the site.
+// Processing decodes and re-encodes them, which drops their EXIF data and so
+// any GPS location a phone recorded.
+type imagePipeline struct {
+	// Whether to process images, otherwise they're copied as they are.
+	Process bool
+	// Images wider than this are scaled down to it, unless it's 0.
+	MaxWidth int `split_words:"true"`
+	// Quality JPEGs are encoded at, defaultQuality if it isn't set.
+	Quality int
+	// Widths of the smaller copies made for responsive images.
+	Widths widths
+	// How images are written in posts, see imageMarkdown, imageFigure and imageSrcset.
+	Markup string
+	// Path to a template for images instead, see figure.
+	Template string
+}
+
+const (
+	// Images stay markdown images.
+	imageMarkdown = "markdown"
+	// Images become Hugo figure shortcodes.
+	imageFigure = "figure"
+	// Images become img tags, listing every width of processed images in a srcset.
+	imageSrcset = "srcset"
+)
+
+const defaultQuality = 85
+
+// widths is a comma separated list of image widths, which can be empty.
+type widths []int
+
+// Decode implements envconfig.Decoder.
+func (w *widths) Decode(v string) error {
+	*w = nil
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid image width %q", s)
+		}
+		*w = append(*w, n)
+	}
+
+	return nil
+}
+
+// processedImage is an image written by the pipeline, in each of its widths.
+type processedImage struct {
+	Name   string
+	Width  int
+	Height int
+	// Smaller copies, narrowest first.
+	Variants []imageVariant
+}
+
+// imageVariant is a smaller copy of a processed image.
+type imageVariant struct {
+	Name  string
+	Width int
+}
+
+// processable reports whether the pipeline handles a file.
+func processable(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	}
+
+	return false
+}
+
+func (p imagePipeline) quality() int {
+	if p.Quality <= 0 || p.Quality > 100 {
+		return defaultQuality
+	}
+
+	return p.Quality
+}
+
+// key identifies what the pipeline makes from a file's content, so
+// changing the settings processes the image again.
+func (p imagePipeline) key(hash string) string {
+	return fmt.Sprintf("%s %d %d %v", hash, p.MaxWidth, p.quality(), p.Widths)
+}
+
+// plan works out the sizes an image is written at from its header, without decoding it.
+func (p imagePipeline) plan(name string, b []byte) (processedImage, error) {
+	c, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return processedImage{}, fmt.Errorf("%s: %s", name, err)
+	}
+
+	// Photos are often stored sideways, with EXIF saying which way up they go.
+	w, h := c.Width, c.Height
+	if jpegOrientation(b) >= 5 {
+		w, h = h, w
+	}
+
+	pi := processedImage{Name: name, Width: w, Height: h}
+	if p.MaxWidth > 0 && w > p.MaxWidth {
+		pi.Width, pi.Height = p.MaxWidth, scaledHeight(w, h, p.MaxWidth)
+	}
+
+	widths := append([]int{}, p.Widths...)
+	sort.Ints(widths)
+	ext := filepath.Ext(name)
+	for i, vw := range widths {
+		if vw <= 0 || vw >= pi.Width || (i > 0 && vw == widths[i-1]) {
+			continue
+		}
+		pi.Variants = append(pi.Variants, imageVariant{
+			Name:  fmt.Sprintf("%s-%dw%s", strings.TrimSuffix(name, ext), vw, ext),
+			Width: vw,
+		})
+	}
+
+	return pi, nil
+}
+
+// scaledHeight is the height of an image scaled to width, keeping its aspect ratio.
+func scaledHeight(w, h, width int) int {
+	sh := (h*width + w/2) / w
+	if sh < 1 {
+		return 1
+	}
+
+	return sh
+}
+
+// process writes an image as planned, and its smaller copies, to dir.
+func (p imagePipeline) process(b []byte, dir string, pi processedImage) error {
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("%s: %s", pi.Name, err)
+	}
+
+	full := orient(toRGBA(img), jpegOrientation(b))
+	full = resize(full, pi.Width, pi.Height)
+	if err := p.writeImage(filepath.Join(dir, pi.Name), full); err != nil {
+		return err
+	}
+
+	for _, v := range pi.Variants {
+		if err := p.writeImage(filepath.Join(dir, v.Name), resize(full, v.Width, scaledHeight(pi.Width, pi.Height, v.Width))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeImage encodes an image into the site in the format its name says it is.
+func (p imagePipeline) writeImage(dst string, img image.Image) error {
+	return writeAtomic(dst, func(w io.Writer) error {
+		if strings.EqualFold(filepath.Ext(dst), ".png") {
+			return (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(w, img)
+		}
+
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: p.quality()})
+	})
+}
+
+// toRGBA converts an image to RGBA with its origin at 0, 0.
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	return dst
+}
+
+// resize scales an image down to w by h, averaging the pixels that make up each new pixel.
+func resize(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if w == sw && h == sh {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, (y+1)*sh/h
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, (x+1)*sw/w
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var sum [4]int
+			n := 0
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					sum[0] += int(src.Pix[i])
+					sum[1] += int(src.Pix[i+1])
+					sum[2] += int(src.Pix[i+2])
+					sum[3] += int(src.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+
+			o := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[o+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+
+	return dst
+}
+
+// orient turns an image the right way up given its EXIF orientation.
+func orient(src *image.RGBA, o int) *image.RGBA {
+	if o < 2 || o > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if o >= 5 {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation of a JPEG, 1 meaning it's the right way up.
+func jpegOrientation(b []byte) int {
+	if len(b) < 2 || b[0] != 0xFF || b[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(b) && b[i] == 0xFF; {
+		marker := b[i+1]
+		// The image data starts after the metadata.
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+
+		// Segment lengths count themselves but not the marker.
+		n := int(binary.BigEndian.Uint16(b[i+2:]))
+		end := i + 2 + n
+		if n < 2 || end > len(b) {
+			break
+		}
+		if seg := b[i+4 : end]; marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return exifOrientation(seg[6:])
+		}
+		i = end
+	}
+
+	return 1
+}
+
+// exifOrientation finds the orientation tag in the first directory of EXIF data.
+func exifOrientation(t []byte) int {
+	if len(t) < 8 {
+		return 1
+	}
+
+	var bo binary.ByteOrder
+	switch string(t[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(bo.Uint32(t[4:]))
+	if ifd < 8 || ifd+2 > len(t) {
+		return 1
+	}
+
+	for i := 0; i < int(bo.Uint16(t[ifd:])); i++ {
+		e := ifd + 2 + i*12
+		if e+12 > len(t) {
+			break
+		}
+		if bo.Uint16(t[e:]) == 0x0112 {
+			if o := int(bo.Uint16(t[e+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+
+	return 1
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testJPEG makes a w by h JPEG, red on the left and blue on the right,
+// with EXIF recording its orientation.
+func testJPEG(t *testing.T, w, h, orientation int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	b := &bytes.Buffer{}
+	require.NoError(t, jpeg.Encode(b, img, nil))
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint16(tiff[18:], uint16(orientation))
+	app1 := append([]byte{0xFF, 0xE1, 0, 0}, append([]byte("Exif\x00\x00"), tiff...)...)
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(app1)-2))
+
+	return append(append(b.Bytes()[:2:2], app1...), b.Bytes()[2:]...)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	for o := 1; o <= 8; o++ {
+		require.Equal(t, o, jpegOrientation(testJPEG(t, 4, 2, o)))
+	}
+
+	require.Equal(t, 1, jpegOrientation([]byte("not a jpeg")))
+	require.Equal(t, 1, jpegOrientation([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00}))
+	require.Equal(t, 1, jpegOrientation([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01, 0xFF, 0xD9}))
+}
+
+func TestOrient(t *testing.T) {
+	// A 2x1 image, red then blue.
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{R: 255, A: 255})
+	src.Set(1, 0, color.RGBA{B: 255, A: 255})
+
+	tests := []struct {
+		orientation int
+		size        image.Point
+		red         image.Point
+	}{
+		{1, image.Pt(2, 1), image.Pt(0, 0)},
+		{2, image.Pt(2, 1), image.Pt(1, 0)},
+		{3, image.Pt(2, 1), image.Pt(1, 0)},
+		{6, image.Pt(1, 2), image.Pt(0, 0)},
+		{8, image.Pt(1, 2), image.Pt(0, 1)},
+	}
+
+	for _, test := range tests {
+		dst := orient(src, test.orientation)
+		require.Equal(t, test.size, dst.Bounds().Size(), "orientation %d", test.orientation)
+		require.Equal(t, color.RGBA{R: 255, A: 255}, dst.RGBAAt(test.red.X, test.red.Y), "orientation %d", test.orientation)
+	}
+}
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		src.Set(x, 0, color.RGBA{R: 200, A: 255})
+		src.Set(x, 1, color.RGBA{B: 100, A: 255})
+	}
+
+	dst := resize(src, 2, 1)
+	require.Equal(t, image.Pt(2, 1), dst.Bounds().Size())
+	require.Equal(t, color.RGBA{R: 100, B: 50, A: 255}, dst.RGBAAt(1, 0))
+	require.Equal(t, src, resize(src, 4, 2))
+}
+
+func TestProcessImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A photo taken on its side, 400x200 as stored but 200x400 the right way up.
+	src := filepath.Join(dir, "bear", "photo.jpg")
+	require.NoError(t, os.MkdirAll(filepath.Dir(src), 0755))
+	require.NoError(t, ioutil.WriteFile(src, testJPEG(t, 400, 200, 6), 0644))
+
+	n := note{Title: "Note", Attachments: []attachment{{Name: "photo.jpg", Path: src}}}
+	static := filepath.Join(dir, "static", "img")
+	p := imagePipeline{Process: true, MaxWidth: 100, Quality: 70, Widths: []int{300, 50, 50, 100}}
+	st := &state{Notes: map[string]noteState{}}
+
+	images, err := copyAttachments(n, static, p, st)
+	require.NoError(t, err)
+	require.Equal(t, map[string]processedImage{
+		"photo.jpg": {Name: "photo.jpg", Width: 100, Height: 200, Variants: []imageVariant{{Name: "photo-50w.jpg", Width: 50}}},
+	}, images)
+
+	for name, size := range map[string]image.Point{"photo.jpg": image.Pt(100, 200), "photo-50w.jpg": image.Pt(50, 100)} {
+		b, err := ioutil.ReadFile(filepath.Join(static, name))
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(b, []byte("Exif")), name)
+
+		img, err := jpeg.Decode(bytes.NewReader(b))
+		require.NoError(t, err)
+		require.Equal(t, size, img.Bounds().Size(), name)
+
+		// Turned the right way up, the red half is on top.
+		r, _, b2, _ := img.At(size.X/2, size.Y/4).RGBA()
+		require.True(t, r > b2, name)
+	}
+
+	// An unchanged image isn't processed again.
+	dst := filepath.Join(static, "photo.jpg")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(dst, old, old))
+	_, err = copyAttachments(n, static, p, st)
+	require.NoError(t, err)
+	info, err := os.Stat(dst)
+	require.NoError(t, err)
+	require.True(t, info.ModTime().Equal(old))
+
+	// Unless the settings change.
+	p.Quality = 90
+	_, err = copyAttachments(n, static, p, st)
+	require.NoError(t, err)
+	info, err = os.Stat(dst)
+	require.NoError(t, err)
+	require.False(t, info.ModTime().Equal(old))
+
+	// Images that can't be decoded are copied as they are.
+	require.NoError(t, ioutil.WriteFile(src, []byte("photo"), 0644))
+	images, err = copyAttachments(n, static, p, st)
+	require.NoError(t, err)
+	require.Empty(t, images)
+	f, err := ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "photo", string(f))
+}
+
+func TestWidthsDecode(t *testing.T) {
+	var w widths
+	require.NoError(t, w.Decode(""))
+	require.Empty(t, w)
+	require.NoError(t, w.Decode("480, 960,"))
+	require.Equal(t, widths{480, 960}, w)
+	require.Error(t, w.Decode("480,wide"))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+)
+
+// layout is where the title, tags and body are in the text of a note.
+// Bear puts the title on the first line, but tags can go on the line after
+// it, at the end of the note or on any line of their own.
+type layout struct {
+	Title []byte
+	// Lines holding the note's hashtags, which aren't part of the body.
+	TagLines [][]byte
+	// Slug the note chose for itself with a slug line under its title.
+	Slug []byte
+	Body [][]byte
+}
+
+// parseLayout finds the parts of a note. The title is the first line that isn't
+// blank or tags, and the line straight after it holds tags if it has any.
+// Any other line of nothing but hashtags is also a tag line, and a slug line
+// can go anywhere between the title and the body.
+func parseLayout(text []byte) layout {
+	l := layout{}
+	d := parseDocument(bytes.Split(text, []byte("\n")))
+
+	title := -1
+	for i, line := range d.lines {
+		switch {
+		case d.code[i]:
+		case title < 0 && len(bytes.TrimSpace(line)) == 0:
+			continue
+		case isTagLine(line):
+			l.TagLines = append(l.TagLines, line)
+			continue
+		case title < 0:
+			title = i
+			l.Title = bytes.TrimSpace(bytes.TrimLeft(line, "#"))
+			continue
+		case i == title+1 && len(findHashtags(stripCode(line))) > 0:
+			l.TagLines = append(l.TagLines, line)
+			continue
+		case l.Slug == nil && blank(l.Body) && slugLine(line) != nil:
+			l.Slug = slugLine(line)
+			continue
+		}
+
+		l.Body = append(l.Body, line)
+	}
+
+	// Blank lines left at the end, usually before tags, aren't part of the body.
+	for len(l.Body) > 0 && len(bytes.TrimSpace(l.Body[len(l.Body)-1])) == 0 {
+		l.Body = l.Body[:len(l.Body)-1]
+	}
+
+	return l
+}
+
+// blank reports whether lines are all blank.
+func blank(lines [][]byte) bool {
+	for _, l := range lines {
+		if len(bytes.TrimSpace(l)) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isTagLine reports whether a line has hashtags and nothing else.
+func isTagLine(line []byte) bool {
+	tags := findHashtags(line)
+	if len(tags) == 0 {
+		return false
+	}
+
+	for i := len(tags) - 1; i >= 0; i-- {
+		line = append(append([]byte{}, line[:tags[i].Start]...), line[tags[i].End:]...)
+	}
+
+	return len(bytes.TrimSpace(line)) == 0
+}
+
+// empty reports whether the note has nothing in its body.
+func (l layout) empty() bool {
+	return len(l.Body) == 0
+}
+
+const (
+	// Notes with nothing in them but a title and tags are exported as drafts.
+	shortNotesDraft = "draft"
+	// Notes with nothing in them are skipped with a warning.
+	shortNotesSkip = "skip"
+	// Notes with nothing in them are skipped and logged as errors.
+	shortNotesError = "error"
+)
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLayout(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		title string
+		tags  []string
+		body  string
+	}{
+		{"usual", "# Title\n#blog/tag\n\nBody", "Title", []string{"#blog/tag"}, "\nBody"},
+		{"tags with text", "# Title\n#blog/tag some text\nBody", "Title", []string{"#blog/tag some text"}, "Body"},
+		{"no tags", "# Title\n\nBody", "Title", nil, "\nBody"},
+		{"tags at the end", "# Title\n\nBody\n\n#blog/tag #other", "Title", []string{"#blog/tag #other"}, "\nBody"},
+		{"tags first", "\n#blog\n# Title\nBody", "Title", []string{"#blog"}, "Body"},
+		{"plain title", "Title\n#blog\nBody #inline", "Title", []string{"#blog"}, "Body #inline"},
+		{"tags between", "# Title\n\nOne\n#blog #other\nTwo", "Title", []string{"#blog #other"}, "\nOne\nTwo"},
+		{"code", "# Title\n#blog\n```\n#define X\n```", "Title", []string{"#blog"}, "```\n#define X\n```"},
+		{"no body", "# Title\n#blog\n\n", "Title", []string{"#blog"}, ""},
+		{"empty", "", "", nil, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := parseLayout([]byte(test.in))
+			require.Equal(t, test.title, string(l.Title))
+
+			tags := []string{}
+			for _, t := range l.TagLines {
+				tags = append(tags, string(t))
+			}
+			if test.tags == nil {
+				test.tags = []string{}
+			}
+			require.Equal(t, test.tags, tags)
+
+			require.Equal(t, test.body, string(bytes.Join(l.Body, []byte("\n"))))
+			require.Equal(t, test.body == "", l.empty())
+		})
+	}
+}
+
+func TestParseLayoutSlug(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		slug string
+		body string
+	}{
+		{"under the title", "# Title\nslug: my-post\n\nBody", "my-post", "\nBody"},
+		{"after tags", "# Title\n#blog\n\nSlug: My Post\nBody", "My Post", "\nBody"},
+		{"in the body", "# Title\n#blog\n\nBody\nslug: later", "", "\nBody\nslug: later"},
+		{"empty", "# Title\nslug:\nBody", "", "slug:\nBody"},
+		{"code", "# Title\n```\nslug: code\n```", "", "```\nslug: code\n```"},
+		{"none", "# Title\n#blog\n\nBody", "", "\nBody"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := parseLayout([]byte(test.in))
+			require.Equal(t, "Title", string(l.Title))
+			require.Equal(t, test.slug, string(l.Slug))
+			require.Equal(t, test.body, string(bytes.Join(l.Body, []byte("\n"))))
+		})
+	}
+}
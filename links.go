@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// wikiLink matches a Bear wiki-link to another note, [[Title]], [[Title/Heading]] or [[Title|Alias]].
+var wikiLink = regexp.MustCompile(`\[\[([^\[\]\n]+)\]\]`)
+
+// bearLink matches a markdown link that opens a note in Bear.
+var bearLink = regexp.MustCompile(`\[([^\]]*)\]\(bear://x-callback-url/open-note\?([^)\s]*)\)`)
+
+// resolveLinks rewrites links to other notes as Hugo relref links to their exported files,
+// which are relative to Hugo's content directory, root. Links to notes that aren't published
+// become plain text, since Hugo fails on a ref it can't find. It returns the titles and IDs
+// the note links to, so it can be exported again when those notes change.
+func resolveLinks(d document, title string, st *state, root string) []string {
+	var links []string
+	d.prose(func(l []byte) []byte {
+		l = wikiLink.ReplaceAllFunc(l, func(m []byte) []byte {
+			link := string(wikiLink.FindSubmatch(m)[1])
+
+			text := link
+			if i := strings.Index(link, "|"); i >= 0 {
+				link, text = link[:i], link[i+1:]
+			}
+			links = append(links, strings.TrimSpace(link))
+
+			// Titles can contain a slash, so only split off a heading if the whole link isn't a note.
+			ns, ok := st.find(link)
+			heading := ""
+			if i := strings.LastIndex(link, "/"); !ok && i >= 0 {
+				ns, ok = st.find(link[:i])
+				heading = link[i+1:]
+			}
+			if !ok {
+				log.Warnf("%s links to %s which isn't published", title, link)
+				return []byte(text)
+			}
+
+			return []byte(fmt.Sprintf("[%s]({{< relref %q >}})", text, relrefPath(ns.Path, root, heading)))
+		})
+
+		l = bearLink.ReplaceAllFunc(l, func(m []byte) []byte {
+			sm := bearLink.FindSubmatch(m)
+			text := string(sm[1])
+
+			q, err := url.ParseQuery(string(sm[2]))
+			if err != nil {
+				log.Warnf("%s has an invalid Bear link: %s", title, err)
+				return []byte(text)
+			}
+
+			// Bear links to a note by its ID, or otherwise its title.
+			ns, ok := st.get(q.Get("id"))
+			links = append(links, q.Get("id"))
+			if q.Get("id") == "" {
+				ns, ok = st.find(q.Get("title"))
+				links[len(links)-1] = q.Get("title")
+			}
+			if !ok || ns.Hash == "" {
+				log.Warnf("%s links to %s which isn't published", title, text)
+				return []byte(text)
+			}
+
+			return []byte(fmt.Sprintf("[%s]({{< relref %q >}})", text, relrefPath(ns.Path, root, q.Get("header"))))
+		})
+
+		return l
+	})
+
+	return links
+}
+
+// relrefPath is the path Hugo's relref expects for an exported file, relative to the content directory root.
+func relrefPath(p, root, heading string) string {
+	if rel, err := filepath.Rel(root, p); err == nil && !strings.HasPrefix(rel, "..") {
+		p = rel
+	}
+	p = "/" + filepath.ToSlash(p)
+	if heading != "" {
+		p += "#" + headingAnchor(heading)
+	}
+
+	return p
+}
+
+// headingAnchor is the ID Hugo generates for a heading, using its default GitHub style.
+func headingAnchor(heading string) string {
+	b := &strings.Builder{}
+	for _, r := range strings.ToLower(strings.TrimSpace(heading)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune('-')
+		}
+	}
+
+	return b.String()
+}
+
+// find returns the state of the published note with the given title.
+// If several notes share the title the first by ID is used, so it is always the same one.
+func (s *state) find(title string) (noteState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []string{}
+	for id, ns := range s.Notes {
+		if ns.Hash != "" && strings.EqualFold(strings.TrimSpace(ns.Title), strings.TrimSpace(title)) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return noteState{}, false
+	}
+
+	sort.Strings(ids)
+	return s.Notes[ids[0]], true
+}
+
+// relink marks the published notes that link to a note to be exported again, because
+// it has been published, moved or unpublished. Links name a note by its ID or its
+// title, so every title it has had is given.
+func (s *state) relink(id string, titles ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	marked := false
+	for lid, ns := range s.Notes {
+		if lid == id || ns.Hash == "" || ns.Relink || !linksTo(ns.Links, id, titles) {
+			continue
+		}
+
+		ns.Relink = true
+		s.Notes[lid] = ns
+		marked = true
+	}
+	if !marked {
+		return nil
+	}
+
+	// Let checkBear know without waiting for it, it may already have been told.
+	select {
+	case s.relinked <- struct{}{}:
+	default:
+	}
+
+	return s.save()
+}
+
+// relinks returns the modification dates of the notes marked to be exported again.
+func (s *state) relinks() map[string]time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := map[string]time.Time{}
+	for id, ns := range s.Notes {
+		if ns.Relink {
+			r[id] = ns.Modified
+		}
+	}
+
+	return r
+}
+
+// linksTo reports whether any of the links is to the note with the ID or one of the titles,
+// including links to a heading in it.
+func linksTo(links []string, id string, titles []string) bool {
+	for _, l := range links {
+		if strings.EqualFold(l, id) {
+			return true
+		}
+
+		for _, t := range titles {
+			t = strings.TrimSpace(t)
+			if t != "" && (strings.EqualFold(l, t) || strings.HasPrefix(strings.ToLower(l), strings.ToLower(t)+"/")) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
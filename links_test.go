@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveLinks(t *testing.T) {
+	st := &state{Notes: map[string]noteState{
+		"POST":    {Title: "Other Post", Path: "content/blog/other-post.md", Hash: "abc"},
+		"SLASH":   {Title: "Either/Or", Path: "content/blog/either-or.md", Hash: "abc"},
+		"DRAFTED": {Title: "Drafted", Path: "content/blog/drafted.md"},
+	}}
+
+	tests := []struct {
+		name string
+		in   string
+		exp  string
+	}{
+		{"no links", "Just text", "Just text"},
+		{"wiki link", "See [[Other Post]].", `See [Other Post]({{< relref "/blog/other-post.md" >}}).`},
+		{"case insensitive", "[[other post]]", `[other post]({{< relref "/blog/other-post.md" >}})`},
+		{"heading", "[[Other Post/Some Heading!]]", `[Other Post/Some Heading!]({{< relref "/blog/other-post.md#some-heading" >}})`},
+		{"alias", "[[Other Post|this post]]", `[this post]({{< relref "/blog/other-post.md" >}})`},
+		{"slash in title", "[[Either/Or]]", `[Either/Or]({{< relref "/blog/either-or.md" >}})`},
+		{"unpublished", "See [[Drafted]]", "See Drafted"},
+		{"missing", "See [[Nothing|that]]", "See that"},
+		{"bear id", "[that post](bear://x-callback-url/open-note?id=POST)", `[that post]({{< relref "/blog/other-post.md" >}})`},
+		{"bear title", "[that post](bear://x-callback-url/open-note?title=Other%20Post&header=Intro)", `[that post]({{< relref "/blog/other-post.md#intro" >}})`},
+		{"bear unpublished", "[that post](bear://x-callback-url/open-note?id=DRAFTED)", "that post"},
+		{"web link", "[site](https://example.com)", "[site](https://example.com)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines := [][]byte{[]byte(test.in)}
+			resolveLinks(parseDocument(lines), "Note", st, "content")
+			require.Equal(t, test.exp, string(lines[0]))
+		})
+	}
+
+	t.Run("code blocks", func(t *testing.T) {
+		lines := bytes.Split([]byte("```\n[[Other Post]]\n```"), []byte("\n"))
+		resolveLinks(parseDocument(lines), "Note", st, "content")
+		require.Equal(t, "```\n[[Other Post]]\n```", string(bytes.Join(lines, []byte("\n"))))
+	})
+}
+
+func TestResolveLinksTargets(t *testing.T) {
+	st := &state{Notes: map[string]noteState{
+		"POST": {Title: "Other Post", Path: "site/posts/other-post.md", Hash: "abc"},
+	}}
+
+	lines := [][]byte{[]byte("[[Other Post/Intro|intro]], [[ Missing ]] and [it](bear://x-callback-url/open-note?id=POST)")}
+	links := resolveLinks(parseDocument(lines), "Note", st, "site")
+	require.Equal(t, `[intro]({{< relref "/posts/other-post.md#intro" >}}),  Missing  and [it]({{< relref "/posts/other-post.md" >}})`, string(lines[0]))
+	require.Equal(t, []string{"Other Post/Intro", "Missing", "POST"}, links)
+
+	require.Nil(t, resolveLinks(parseDocument([][]byte{[]byte("No links")}), "Note", st, "site"))
+}
+
+func TestRelink(t *testing.T) {
+	st := &state{Notes: map[string]noteState{
+		"TARGET":  {Title: "Target", Hash: "abc", Links: []string{"Target"}},
+		"TITLE":   {Title: "Title", Hash: "abc", Links: []string{"target"}},
+		"HEADING": {Title: "Heading", Hash: "abc", Links: []string{"Target/Intro"}},
+		"ID":      {Title: "ID", Hash: "abc", Links: []string{"TARGET"}},
+		"OLD":     {Title: "Old", Hash: "abc", Links: []string{"Old Title"}},
+		"PREFIX":  {Title: "Prefix", Hash: "abc", Links: []string{"Targets"}},
+		"DRAFT":   {Title: "Draft", Links: []string{"Target"}},
+	}, relinked: make(chan struct{}, 1)}
+
+	require.NoError(t, st.relink("TARGET", "Old Title", "Target"))
+	require.Len(t, st.relinked, 1)
+
+	relinked := []string{}
+	for id := range st.relinks() {
+		relinked = append(relinked, id)
+	}
+	require.ElementsMatch(t, []string{"TITLE", "HEADING", "ID", "OLD"}, relinked)
+}
+
+func TestHeadingAnchor(t *testing.T) {
+	for in, exp := range map[string]string{
+		"Heading":            "heading",
+		" Two Words ":        "two-words",
+		"What's new? (2019)": "whats-new-2019",
+		"snake_case-heading": "snake_case-heading",
+		"Ünïcode Héading":    "ünïcode-héading",
+	} {
+		require.Equal(t, exp, headingAnchor(in))
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"io/ioutil"
 	"os"
 	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"syscall"
-	"text/template"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -21,38 +23,70 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// note is a Bear note, and what templates are executed with. Besides its
+// Title and ID, templates can use:
+//
+//	.Body        the note converted for Hugo
+//	.Date        when the note was created, or the date of its existing post
+//	.LastMod     when the note was last changed
+//	.Created     .Modified as times, for example {{ .Created.Format "2006" }}
+//	.Hashtags    the note's tags, which are its categories or tags
+//	.Categories  .Tags whether the hashtags are categories, tags or both
+//	.Draft       whether the note is a draft
+//	.Slug        the name of the note's file or bundle
+//	.Route       the Bear tag of the route the note came through
+//	.Section     the section a nested tag chose, if any
+//	.WordCount   the number of words in the note's text
+//	.FirstImage  the link to the note's first image, if it has one
+//	.CustomFrontMatter  front matter lines kept from the existing post
+//
+// along with the helpers in templateFuncs.
 type note struct {
+	PK                int64  `db:"Z_PK"`
+	ID                string `db:"ZUNIQUEIDENTIFIER"`
 	Title             string `db:"ZTITLE"`
 	BodyRaw           []byte `db:"ZTEXT"`
+	Hash              string
+	Created           coreDataTime `db:"ZCREATIONDATE"`
+	Modified          coreDataTime `db:"ZMODIFICATIONDATE"`
+	Changed           coreDataTime `db:"CHANGED"`
+	Trashed           bool         `db:"ZTRASHED"`
+	Archived          bool         `db:"ZARCHIVED"`
+	Tagged            bool         `db:"TAGGED"`
+	Markup            markup
 	Body              string
 	Date              string
+	LastMod           string
 	Hashtags          []string
+	BearTags          []string
+	Attachments       []attachment
 	CustomFrontMatter []string
 	Categories        bool
 	Tags              bool
 	Draft             bool
+	Slug              string
+	Route             string
+	Section           string
+	WordCount         int
+	FirstImage        string
+}
+
+// published reports whether the note belongs on the blog.
+func (n note) published() bool {
+	return n.Tagged && !n.Trashed && !n.Archived
 }
 
 const templateRaw = `---
-title: "{{ .Title }}"
+title: {{ yamlQuote .Title }}
 date: {{ .Date }}
+lastmod: {{ .LastMod }}
 
 {{- if .Categories }}
-categories: [
-{{- range $i, $c := .Hashtags -}}
-	{{- if $i -}},{{- end -}}
-	"{{- $c -}}"
-{{- end -}}
-]
+categories: {{ yamlList .Hashtags }}
 {{- end }}
 
 {{- if .Tags }}
-tags: [
-{{- range $i, $c := .Hashtags -}}
-	{{- if $i -}},{{- end -}}
-	"{{- $c -}}"
-{{- end -}}
-]
+tags: {{ yamlList .Hashtags }}
 {{- end }}
 draft: {{ .Draft }}
 {{- range $l := .CustomFrontMatter }}
@@ -61,10 +95,51 @@ draft: {{ .Draft }}
 ---
 {{ .Body }}`
 
+// templateTOML is templateRaw with TOML front matter.
+const templateTOML = `+++
+title = {{ tomlQuote .Title }}
+date = {{ tomlDate .Date }}
+lastmod = {{ tomlDate .LastMod }}
+
+{{- if .Categories }}
+categories = {{ tomlList .Hashtags }}
+{{- end }}
+
+{{- if .Tags }}
+tags = {{ tomlList .Hashtags }}
+{{- end }}
+draft = {{ .Draft }}
+{{- range $l := .CustomFrontMatter }}
+{{ $l }}
+{{- end }}
++++
+{{ .Body }}`
+
+// templateJSON is templateRaw with JSON front matter.
+const templateJSON = `{
+  "title": {{ jsonQuote .Title }},
+  "date": {{ jsonQuote .Date }},
+  "lastmod": {{ jsonQuote .LastMod }},
+
+{{- if .Categories }}
+  "categories": {{ jsonList .Hashtags }},
+{{- end }}
+
+{{- if .Tags }}
+  "tags": {{ jsonList .Hashtags }},
+{{- end }}
+  "draft": {{ .Draft }}
+{{- range $m := .CustomFrontMatter }},
+  {{ $m }}
+{{- end }}
+}
+{{ .Body }}`
+
 // Front matter that Bhguo manages.
 var bhugoFrontMatter = map[string]bool{
 	"title":      true,
 	"date":       true,
+	"lastmod":    true,
 	"categories": true,
 	"tags":       true,
 	"draft":      true,
@@ -79,14 +154,37 @@ func main() {
 	}
 
 	var cfg struct {
-		Interval   time.Duration `default:"1s"`
-		HugoDir    string        `split_words:"true" required:"true"`
-		ContentDir string        `split_words:"true" default:"content/blog"`
-		ImageDir   string        `split_words:"true" default:"/img/posts"`
-		NoteTag    string        `split_words:"true" default:"blog"`
-		Database   string        `required:"true"`
-		Categories bool          `default:"true"`
-		Tags       bool          `default:"false"`
+		Interval    time.Duration `default:"1s"`
+		Watch       bool          `default:"true"`
+		Debounce    time.Duration `default:"250ms"`
+		HugoDir     string        `split_words:"true" required:"true"`
+		ContentDir  string        `split_words:"true" default:"content/blog"`
+		ContentRoot string        `split_words:"true" default:"content"`
+		ImageDir    string        `split_words:"true" default:"/img/posts"`
+		NoteTag     string        `split_words:"true" default:"blog"`
+		Source      string        `default:"sqlite"`
+		Polar       bool          `default:"false"`
+		Database    string        `envconfig:"DATABASE"`
+		ExportDir   string        `split_words:"true"`
+		Categories  bool          `default:"true"`
+		Tags        bool          `default:"false"`
+		NestedTags  string        `split_words:"true" default:"flat"`
+		InlineTags  string        `split_words:"true" default:"text"`
+		ShortNotes  string        `split_words:"true" default:"skip"`
+		Attachments bool          `envconfig:"COPY_ATTACHMENTS" default:"true"`
+		Bundles     bool          `envconfig:"PAGE_BUNDLES" default:"false"`
+		Punctuation punctuation   `envconfig:"SMART_PUNCTUATION"`
+		Images      imagePipeline `envconfig:"IMAGES"`
+		Format      string        `envconfig:"FRONT_MATTER_FORMAT"`
+		Template    string        `envconfig:"TEMPLATE"`
+		Routes      []string      `envconfig:"ROUTES"`
+		OnTrash     string        `split_words:"true" default:"draft"`
+		OnArchive   string        `split_words:"true" default:"draft"`
+		OnUntag     string        `split_words:"true" default:"draft"`
+		ArchiveDir  string        `split_words:"true" default:"content/archive"`
+		StateFile   string        `split_words:"true" default:".bhugo-state.json"`
+		StateXDG    bool          `envconfig:"STATE_XDG" default:"false"`
+		Timezone    string        `default:"Local"`
 	}
 
 	err = envconfig.Process("", &cfg)
@@ -94,82 +192,260 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Override these defaults with the configuration values.
-	bhugoFrontMatter["categories"] = cfg.Categories
-	bhugoFrontMatter["tags"] = cfg.Tags
+	rp := removalPolicy{
+		Trashed:    cfg.OnTrash,
+		Archived:   cfg.OnArchive,
+		Untagged:   cfg.OnUntag,
+		ArchiveDir: cfg.ArchiveDir,
+	}
+	if err := rp.validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Each route falls back on the top level configuration.
+	routes, err := loadRoutes(cfg.Routes, route{
+		Tag:         cfg.NoteTag,
+		ContentDir:  cfg.ContentDir,
+		ContentRoot: cfg.ContentRoot,
+		ImageDir:    cfg.ImageDir,
+		Categories:  cfg.Categories,
+		Tags:        cfg.Tags,
+		NestedTags:  cfg.NestedTags,
+		InlineTags:  cfg.InlineTags,
+		ShortNotes:  cfg.ShortNotes,
+		Attachments: cfg.Attachments,
+		Bundles:     cfg.Bundles,
+		Punctuation: cfg.Punctuation,
+		Images:      cfg.Images,
+		Format:      cfg.Format,
+		Template:    cfg.Template,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	timeFormat := "2006-01-02T15:04:05-07:00"
 
-	db, err := sql.Connect("sqlite3", cfg.Database)
+	loc, err := time.LoadLocation(cfg.Timezone)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	tmpl, err := template.New("Note Template").Parse(templateRaw)
+	// A relative state file lives alongside the Hugo site it describes,
+	// unless it has been moved out of the site into the XDG state directory.
+	switch {
+	case filepath.IsAbs(cfg.StateFile):
+	case cfg.StateXDG:
+		dir, err := siteStateDir(cfg.HugoDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatal(err)
+		}
+		cfg.StateFile = filepath.Join(dir, cfg.StateFile)
+	default:
+		cfg.StateFile = filepath.Join(cfg.HugoDir, cfg.StateFile)
+	}
+
+	st, err := loadState(cfg.StateFile)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Notes come from Bear's database, or a folder of notes exported from Bear.
+	var src noteSource
+	watched := cfg.Database
+	switch cfg.Source {
+	case "sqlite":
+		if cfg.Database == "" {
+			log.Fatal("required key DATABASE missing value")
+		}
+
+		db, err := sql.Connect("sqlite3", cfg.Database)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		bear, err := detectSchema(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("Found a Bear %d database", bear.Version)
+
+		// Bear 1 notes are in Polar markup when markdown compatibility mode is off.
+		if cfg.Polar {
+			if bear.Version == 1 {
+				bear.Markup = polar
+			} else {
+				log.Warnf("Bear %d only writes markdown, ignoring POLAR", bear.Version)
+			}
+		}
+
+		src = &sqliteSource{db: db, schema: bear, files: filepath.Join(filepath.Dir(cfg.Database), "Local Files")}
+	case "folder":
+		if cfg.ExportDir == "" {
+			log.Fatal("required key EXPORT_DIR missing value")
+		}
+
+		// Exports can be nested in any number of folders, which can't be watched.
+		src = &folderSource{dir: cfg.ExportDir, polar: cfg.Polar}
+		cfg.Watch = false
+	default:
+		log.Fatalf("unknown source %q, expected sqlite or folder", cfg.Source)
+	}
+
 	sigs := make(chan os.Signal, 1)
 	done := make(chan bool, 2)
+	stop := make(chan struct{})
 	notes := make(chan note, 1)
 
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
 	wg := sync.WaitGroup{}
 
-	log.Infof("Watching Bear tag #%s for changes", cfg.NoteTag)
+	for _, r := range routes {
+		log.Infof("Watching Bear tag #%s for changes to %s", r.Tag, r.ContentDir)
+	}
+
+	// Prefer waiting on writes to the database, falling back to polling when it can't be watched.
+	var changes <-chan struct{}
+	if cfg.Watch {
+		changes, err = watchDatabase(stop, watched, cfg.Debounce)
+		if err != nil {
+			log.Warnf("Unable to watch %s, polling every %s instead: %s", watched, cfg.Interval, err)
+		}
+	}
+	if changes == nil {
+		changes = pollDatabase(stop, cfg.Interval)
+	}
 
 	wg.Add(1)
-	go checkBear(&wg, done, db, cfg.Interval, notes, cfg.NoteTag)
+	go checkBear(&wg, done, src, changes, notes, routeTags(routes), st)
 
 	wg.Add(1)
-	go updateHugo(&wg, done, notes, time.Now, timeFormat, cfg.NoteTag, cfg.HugoDir, cfg.ContentDir, cfg.ImageDir, tmpl, cfg.Categories, cfg.Tags)
+	go updateHugo(&wg, done, notes, time.Now, loc, timeFormat, cfg.HugoDir, routes, st, rp)
 
 	go func() {
 		sig := <-sigs
 		log.Info(sig)
 		done <- true
 		done <- true
+		close(stop)
 	}()
 
 	wg.Wait()
 	log.Info("Bhugo Exiting")
 }
 
-func checkBear(wg *sync.WaitGroup, done <-chan bool, db *sql.DB, interval time.Duration, notesChan chan<- note, noteTag string) {
+func checkBear(wg *sync.WaitGroup, done <-chan bool, src noteSource, changes <-chan struct{}, notesChan chan<- note, noteTags []string, st *state) {
 	log.Debug("Starting CheckBear")
 
 	defer wg.Done()
 
-	tick := time.Tick(interval)
-	cache := make(map[string][]byte)
-
-	for {
-		select {
-		case <-tick:
-			notes := []note{}
-			q := fmt.Sprintf("SELECT ZTITLE, ZTEXT FROM ZSFNOTE WHERE ZTEXT LIKE '%%#%s%%'", noteTag)
-			if err := db.Select(&notes, q); err != nil {
-				log.Error(err)
-				continue
+	// Notes are keyed by their Bear ID since titles can change or be shared.
+	// Seeding the cache with the exported versions means anything new or
+	// changed while Bhugo wasn't running is exported on the first check.
+	cache := st.hashes()
+
+	// Only notes modified after the high water mark are loaded from Bear.
+	// It starts from the newest exported note so offline changes are picked up.
+	mark := coreDataTime{Time: st.latest()}
+
+	// check sends any changed notes to Hugo and reports whether to keep going.
+	check := func() bool {
+		// Notes whose links need resolving again are exported even though they haven't
+		// changed, so look far enough back to find them.
+		since := mark
+		relinks := st.relinks()
+		for _, m := range relinks {
+			if t := (coreDataTime{Time: m.Add(-time.Second)}); since.after(t) {
+				since = t
 			}
+		}
 
-			// Initialize cache for any new notes with changes.
-			for _, n := range notes {
-				c, ok := cache[n.Title]
-				if !ok {
-					cache[n.Title] = n.BodyRaw
+		notes, err := src.changed(noteTags, since)
+		if err != nil {
+			log.Error(err)
+			return true
+		}
+
+		failed := false
+		for _, n := range notes {
+			switch h, ok := cache[n.ID]; {
+			// Notes that leave the blog are sent to be unpublished, but only once.
+			case !n.published():
+				if !ok || h == "" {
+					continue
+				}
+				log.Infof("%s is no longer published - updating Hugo", n.Title)
+				n.Hash = ""
+
+			default:
+				if err := src.load(&n); err != nil {
+					log.Error(err)
+					failed = true
 					continue
 				}
 
-				if !bytes.Equal(c, n.BodyRaw) {
+				n.Hash = noteHash(n.BodyRaw)
+				_, relink := relinks[n.ID]
+				if h == n.Hash && !relink {
+					continue
+				}
+
+				switch {
+				case h == n.Hash:
+					log.Infof("A note linked to from %s has changed - updating Hugo", n.Title)
+				case ok:
 					log.Infof("Differences detected in %s - updating Hugo", n.Title)
-					cache[n.Title] = n.BodyRaw
-					notesChan <- n
+				default:
+					log.Infof("New note %s detected - updating Hugo", n.Title)
 				}
 			}
 
+			select {
+			case notesChan <- n:
+				cache[n.ID] = n.Hash
+			case <-done:
+				return false
+			}
+		}
+
+		// The mark only moves once every note has been handed to Hugo, so a
+		// note that couldn't be loaded is tried again on the next check.
+		if !failed {
+			for _, n := range notes {
+				if n.Changed.after(mark) {
+					mark = n.Changed
+				}
+			}
+		}
+
+		return true
+	}
+
+	// Reconcile with the last exported state before waiting on the first change.
+	if !check() {
+		log.Info("Check Bear exiting")
+		return
+	}
+
+	for {
+		select {
+		case <-changes:
+			if !check() {
+				log.Info("Check Bear exiting")
+				return
+			}
+
+		// Hugo has marked notes to export again because of their links.
+		case <-st.relinked:
+			if !check() {
+				log.Info("Check Bear exiting")
+				return
+			}
+
 		case <-done:
 			log.Info("Check Bear exiting")
 			return
@@ -177,69 +453,306 @@ func checkBear(wg *sync.WaitGroup, done <-chan bool, db *sql.DB, interval time.D
 	}
 }
 
-func updateHugo(wg *sync.WaitGroup, done <-chan bool, notes <-chan note, timeProvider func() time.Time, timeFormat, noteTag, hugoDir, contentDir, imageDir string, tmpl *template.Template, categories, tags bool) {
+func updateHugo(wg *sync.WaitGroup, done <-chan bool, notes <-chan note, timeProvider func() time.Time, loc *time.Location, timeFormat, hugoDir string, routes []route, st *state, rp removalPolicy) {
 	log.Debug("Starting UpdateHugo")
 	defer wg.Done()
 
 	for {
 		select {
 		case n := <-notes:
-			// Replace smart quotes with regular quotes.
-			n.BodyRaw = bytes.Replace(n.BodyRaw, []byte("“"), []byte("\""), -1)
-			n.BodyRaw = bytes.Replace(n.BodyRaw, []byte("”"), []byte("\""), -1)
+			if !n.published() {
+				old, exported := st.get(n.ID)
+				rp.apply(n, hugoDir, st)
+
+				// Links to it from other notes become plain text.
+				if exported && old.Hash != "" {
+					if err := st.relink(n.ID, old.Title); err != nil {
+						log.Error(err)
+					}
+				}
+				continue
+			}
+
+			// Posts are dated by when the note was created so that republishing
+			// an old note doesn't move it, and the last edit becomes lastmod.
+			created := n.Created.Time
+			if created.IsZero() {
+				created = timeProvider()
+			}
+			modified := n.Modified.Time
+			if modified.IsZero() {
+				modified = created
+			}
+			n.Date = created.In(loc).Format(timeFormat)
+			n.LastMod = modified.In(loc).Format(timeFormat)
 
-			n.Date = timeProvider().Format(timeFormat)
+			l := parseLayout(n.BodyRaw)
 
-			lines := bytes.Split(n.BodyRaw, []byte("\n"))
-			// If there is only a heading and tags continue on.
-			if len(lines) < 3 {
+			// Use the tags Bear has recorded for the note, otherwise the tags in its text.
+			bearTags := n.BearTags
+			if bearTags == nil {
+				bearTags = textTags(n.BodyRaw)
+			}
+
+			r, ok := routeFor(routes, bearTags)
+			if !ok {
+				log.Warnf("%s doesn't have the tag of any route", n.Title)
 				continue
 			}
 
-			// The second line should be the line with tags.
-			n.Hashtags = scanTags(lines[1], noteTag)
+			if n.BearTags != nil {
+				n.Hashtags = []string{}
+				for _, t := range leafTags(n.BearTags) {
+					n.Hashtags = append(n.Hashtags, formatTag([]byte(t), r.Tag))
+				}
+			} else {
+				// Without Bear's tags, collect the hashtags from the tag lines and then the body.
+				n.Hashtags = []string{}
+				for _, t := range l.TagLines {
+					n.Hashtags = append(n.Hashtags, scanTags(t, r.Tag)...)
+				}
+				for _, t := range textTags(bytes.Join(l.Body, []byte("\n"))) {
+					n.Hashtags = append(n.Hashtags, formatTag([]byte(t), r.Tag))
+				}
+				n.Hashtags = uniqueTags(n.Hashtags)
+			}
+
+			// A note with only a title and tags is handled by the route's policy.
+			if l.empty() {
+				switch r.ShortNotes {
+				case shortNotesDraft:
+					log.Infof("%s has no body yet - exporting it as a draft", n.Title)
+					n.Draft = true
+				case shortNotesError:
+					log.Errorf("%s has no body, not exporting it", n.Title)
+					continue
+				default:
+					log.Warnf("%s has no body, skipping it", n.Title)
+					continue
+				}
+			}
+
+			// A nested tag can choose the section the note is written to, instead of being a category.
+			dir := r.ContentDir
+			if r.NestedTags == nestedSections {
+				if section := r.section(bearTags); section != "" {
+					if err := createSections(hugoDir, r.ContentDir, section); err != nil {
+						log.Error(err)
+						continue
+					}
+					dir = filepath.Join(r.ContentDir, sectionDir(section))
+					n.Section = section
+
+					hashtags := []string{}
+					for _, h := range n.Hashtags {
+						if !strings.EqualFold(h, strings.Title(section)) {
+							hashtags = append(hashtags, h)
+						}
+					}
+					n.Hashtags = hashtags
+				}
+			}
 			for _, c := range n.Hashtags {
 				if strings.Contains(strings.ToLower(c), "draft") {
 					n.Draft = true
 				}
 			}
 
-			// The Bear hashtags will populate either categories or tags (or both) depending on these bools.
-			n.Categories = categories
-			n.Tags = tags
+			n.Route = r.Tag
+
+			// The Bear hashtags will populate either categories or tags (or both) depending on the route.
+			n.Categories = r.Categories
+			n.Tags = r.Tags
+
+			// Notes are named after their title, unless they choose their own slug.
+			n.Slug = slugify(n.Title)
+			if l.Slug != nil {
+				n.Slug = slugify(string(l.Slug))
+			}
+			if n.Slug == "" {
+				n.Slug = shortID(n.ID)
+			}
+			rel := r.notePath(dir, n.Slug)
+
+			// Two notes can have the same slug, so if another note already owns
+			// this file disambiguate with the note's ID rather than overwrite it.
+			// The note exported first keeps the slug so its post doesn't move, which
+			// is the older note when they're exported together.
+			if id, ok := st.owner(rel); ok && id != n.ID {
+				slug := fmt.Sprintf("%s-%s", n.Slug, shortID(n.ID))
+				if id, ok := st.owner(r.notePath(dir, slug)); ok && id != n.ID {
+					slug = fmt.Sprintf("%s-%s", n.Slug, strings.ToLower(n.ID))
+				}
+				log.Warnf("%s has the same slug as another note, writing it to %s", n.Title, slug)
+				n.Slug = slug
+				rel = r.notePath(dir, slug)
+			}
+
+			fp := filepath.Join(hugoDir, rel)
+
+			// If the note was renamed, its previous file is replaced by the new one.
+			var prev string
+			var prevBundle bool
+			old, exported := st.get(n.ID)
+			if exported && old.Path != rel {
+				prev, prevBundle = filepath.Join(hugoDir, old.Path), old.Bundle
+			}
+
+			// A renamed bundle is moved as a whole, along with anything else in it.
+			if prev != "" && prevBundle && r.Bundles {
+				if _, err := os.Stat(filepath.Dir(fp)); os.IsNotExist(err) {
+					log.Infof("%s was renamed - moving %s to %s", n.Title, filepath.Dir(prev), filepath.Dir(fp))
+					if err := os.MkdirAll(filepath.Dir(filepath.Dir(fp)), 0755); err != nil {
+						log.Error(err)
+						continue
+					}
+					if err := os.Rename(filepath.Dir(prev), filepath.Dir(fp)); err != nil {
+						log.Error(err)
+						continue
+					}
+					prev = ""
+				}
+			}
+
+			// Copy the note's images and files into its bundle or its own folder of the site's static files.
+			imgDir := r.ImageDir
+			var images map[string]processedImage
+			if r.Attachments {
+				imgDir = path.Join(r.ImageDir, attachmentDir(n))
+				dst := filepath.Join(hugoDir, "static", imgDir)
+				if r.Bundles {
+					dst = filepath.Dir(fp)
+				}
+				var err error
+				images, err = copyAttachments(n, dst, r.Images, st)
+				if err != nil {
+					log.Error(err)
+				}
+			}
+
+			body := parseDocument(l.Body)
+			n.WordCount = body.words()
+
+			// Page bundles keep their images beside the note rather than in the image dir,
+			// unless they aren't copied, when they're still where they were put by hand.
+			if r.Bundles && r.Attachments {
+				imgDir = ""
+			}
+			w := imageWriter{dir: imgDir, markup: r.Images.Markup, tmpl: r.imgTmpl, processed: images, folders: r.Attachments}
+
+			// Format images and attachments for Hugo.
+			var figures []figure
+			switch n.Markup {
+			case bear2:
+				figures = parseImagesBear2(body, w)
+			case polar:
+				convertPolar(body)
+				fallthrough
+			default:
+				figures = parseImages(body, w)
+				parseFiles(body, imgDir, r.Attachments)
+			}
+			if len(figures) > 0 {
+				n.FirstImage = figures[0].Src
+			}
+
+			// Link to the other notes on the site.
+			links := resolveLinks(body, n.Title, st, r.ContentRoot)
 
-			// Format images for Hugo.
-			parseImages(lines, imageDir)
+			// Hashtags in the body are in the front matter, so they don't need to show as hashtags.
+			body.inlineTags(r.InlineTags, r.taxonomy(), r.Tag, n.Hashtags)
 
-			// First two lines are the title of the note and the tags.
-			n.Body = string(bytes.Join(lines[2:], []byte("\n")))
-			target := strings.Replace(strings.ToLower(n.Title), " ", "-", -1)
+			// Replace smart punctuation with the plain characters.
+			body.punctuate(r.Punctuation)
 
-			fp := fmt.Sprintf("%s/%s/%s.md", hugoDir, contentDir, target)
+			n.Body = string(body.bytes())
 
 			cf, err := ioutil.ReadFile(fp)
 			if err != nil && !os.IsNotExist(err) {
 				log.Error(err)
 				continue
 			}
-			// If the file exists, check for any custom front matter to preserve it.
-			if len(cf) > 0 {
-				n.CustomFrontMatter = customFrontMatter(cf)
+			// Carry the custom front matter over from the previous file of a renamed note.
+			if len(cf) == 0 && prev != "" {
+				cf, err = ioutil.ReadFile(prev)
+				if err != nil && !os.IsNotExist(err) {
+					log.Error(err)
+					continue
+				}
+			}
+			// If the file exists, check for any custom front matter to preserve it
+			// and keep its date so the post stays where it was published.
+			// Its front matter format is kept too, unless one is configured or the route's
+			// template writes another, in which case its custom front matter is converted to it.
+			format := r.Format
+			if r.tmplFormat != "" {
+				format = r.tmplFormat
+			}
+			d, _, existing := readFrontMatter(cf)
+			if existing {
+				if format == "" {
+					format = d.format
+				}
+				date := d.value("date")
+				if d.format == format {
+					n.CustomFrontMatter = d.custom(r.frontMatter())
+				} else if custom, v, err := d.convert(format, r.frontMatter()); err != nil {
+					log.Warnf("Unable to read the %s front matter of %s, its custom front matter can't be kept in %s: %s", d.format, fp, format, err)
+				} else {
+					n.CustomFrontMatter = custom
+					date = v
+				}
+				if date != "" {
+					n.Date = date
+				}
+			}
+			if format == "" {
+				format = siteFrontMatterFormat(hugoDir)
+			}
+
+			b := &bytes.Buffer{}
+			if err := r.template(format).Execute(b, n); err != nil {
+				log.Error(err)
+				continue
+			}
+
+			// The existing front matter is updated rather than replaced, so anything
+			// added to it stays where it was.
+			post := b.Bytes()
+			if existing {
+				if written, rest, ok := readFrontMatter(post); ok && written.format == d.format {
+					d.merge(written, r.frontMatter())
+					post = append(d.bytes(), rest...)
+				}
 			}
 
-			f, err := os.Create(fp)
-			if err != nil {
+			if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
 				log.Error(err)
 				continue
 			}
 
-			if err := tmpl.Execute(f, n); err != nil {
+			if err := ioutil.WriteFile(fp, post, 0666); err != nil {
 				log.Error(err)
+				continue
 			}
 
-			if err := f.Close(); err != nil {
+			if prev != "" {
+				log.Infof("%s was renamed - moving %s to %s", n.Title, prev, fp)
+				if err := removeNote(prev, prevBundle); err != nil {
+					log.Error(err)
+				}
+			}
+
+			if err := st.set(n.ID, noteState{Title: n.Title, Path: rel, Bundle: r.Bundles, Hash: n.Hash, Modified: n.Modified.Time, Links: links}); err != nil {
 				log.Error(err)
 			}
+
+			// Links to the note from other notes change when it's published, moved or retitled.
+			if !exported || old.Hash == "" || old.Path != rel || old.Title != n.Title {
+				if err := st.relink(n.ID, old.Title, n.Title); err != nil {
+					log.Error(err)
+				}
+			}
 		case <-done:
 			log.Info("Update Hugo exiting")
 			return
@@ -304,63 +817,81 @@ func scanTags(l []byte, tag string) []string {
 	return hashtags
 }
 
-func parseImages(lines [][]byte, imgDir string) {
-	caption := false
-
+// parseImages writes the Bear 1 images in a document for Hugo, returning them in order.
+func parseImages(d document, w imageWriter) []figure {
+	figures := []figure{}
 	// Go through all the lines and check for images.
 	// Replace the Bear image format with the Hugo format and the captions.
-	for i, l := range lines {
-		switch {
-		case caption:
-			caption = false
+	for i, l := range d.lines {
+		if d.code[i] || !bytes.Contains(stripCode(l), []byte("[image:")) {
+			continue
+		}
 
-			// Assume captions are italics or bold.
-			if bytes.HasPrefix(l, []byte("*")) {
-				lines[i-1] = bytes.Replace(lines[i-1], []byte("--caption--"), bytes.Trim(l, "*"), -1)
-			} else {
-				lines[i-1] = bytes.Replace(lines[i-1], []byte("--caption--"), []byte(""), -1)
-			}
-		case bytes.Contains(l, []byte("[image:")):
-			// Next line is possibly the image caption.
-			caption = true
-			split := bytes.Split(l, []byte("/"))
-			if len(split) != 2 {
-				log.Warn("Parsing image line failed")
-				continue
-			}
+		split := bytes.Split(l, []byte("/"))
+		if len(split) != 2 {
+			log.Warn("Parsing image line failed")
+			continue
+		}
 
-			imgName := string(bytes.TrimSuffix(bytes.TrimSpace(split[1]), []byte("]")))
-			lines[i] = []byte(fmt.Sprintf("![--caption--](%s/%s)", imgDir, imgName))
+		name := string(bytes.TrimSuffix(bytes.TrimSpace(split[1]), []byte("]")))
+		src := w.link(name)
+		if w.folders {
+			id := bytes.TrimPrefix(bytes.TrimSpace(split[0]), []byte("[image:"))
+			src = linkPath(w.dir, string(id)+"/"+name)
+		}
+
+		// Next line is possibly the image caption.
+		f := figure{Src: src, Caption: caption(d, i)}
+		d.lines[i] = w.write(f)
+		figures = append(figures, f)
+		if f.Caption != "" && w.captions() {
+			d.lines[i+1] = []byte{}
 		}
 	}
+
+	return figures
 }
 
-func customFrontMatter(f []byte) []string {
-	lines := bytes.Split(f, []byte("\n"))
-	fm := []string{}
+var bear2Image = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)\)(\s*<!--.*?-->)?`)
 
-	for i, l := range lines {
-		kv := bytes.Split(l, []byte(":"))
+// parseImagesBear2 writes the Bear 2 images in a document for Hugo, returning them in order.
+func parseImagesBear2(d document, w imageWriter) []figure {
+	figures := []figure{}
+	// Bear 2 images are already markdown, but they link to a file beside the note
+	// and are followed by a comment with Bear's display settings.
+	for i, l := range d.lines {
+		if d.code[i] || !bear2Image.Match(stripCode(l)) {
+			continue
+		}
 
-		switch {
-		case i == 0:
-			// First line should be dashes.
-			if !bytes.Equal(l, []byte("---")) {
-				return []string{}
-			}
+		captioned := false
+		d.lines[i] = inlineProse(l, func(p []byte) []byte {
+			return bear2Image.ReplaceAllFunc(p, func(m []byte) []byte {
+				sm := bear2Image.FindSubmatch(m)
+				f := figure{Src: w.link(string(sm[2])), Alt: string(sm[1])}
+				f.setMeta(sm[3])
+
+				// Without alt text, the next line is possibly the image caption.
+				if f.Alt == "" {
+					f.Caption = caption(d, i)
+					captioned = captioned || f.Caption != ""
+				}
 
-		// If this line is front matter that Bhguo controls, don't append it.
-		case bhugoFrontMatter[string(kv[0])]:
-			continue
-		case bytes.Equal(l, []byte("---")):
-			return fm
-		default:
-			fm = append(fm, string(l))
+				figures = append(figures, f)
+				return w.write(f)
+			})
+		})
+		if captioned && w.captions() {
+			d.lines[i+1] = []byte{}
 		}
 	}
 
-	// Should not reach this if file is formatted correctly.
-	return []string{}
+	return figures
+}
+
+// shortID is an abbreviated Bear note ID suitable for use in file names.
+func shortID(id string) string {
+	return strings.ToLower(strings.SplitN(id, "-", 2)[0])
 }
 
 func formatTag(l []byte, tag string) string {
@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
 	"testing"
 	"text/template"
 	"time"
 
+	sql "github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
 )
 
@@ -25,7 +29,9 @@ func TestUpdateHugo(t *testing.T) {
 			"basic",
 			"note-title.md",
 			note{
-				Title: "Note Title",
+				ID:     "NOTE-1",
+				Title:  "Note Title",
+				Tagged: true,
 				BodyRaw: []byte(`# Note Title
 #blog/tag
 
@@ -33,6 +39,7 @@ Body text`)},
 			[]byte(`---
 title: "Note Title"
 date: %time%
+lastmod: %time%
 categories: ["Tag"]
 tags: ["Tag"]
 draft: false
@@ -41,25 +48,120 @@ draft: false
 Body text`),
 			true,
 		},
-		// Should preserve custom front matter of an existing note.
+		// Tags recorded by Bear take precedence over the tag line.
+		{
+			"bear tags",
+			"tagged.md",
+			note{
+				ID:       "TAGGED-1",
+				Title:    "Tagged",
+				Tagged:   true,
+				BearTags: []string{"blog", "blog/tag", "other"},
+				BodyRaw: []byte(`# Tagged
+#blog/tag #other
+
+Body text`)},
+			[]byte(`---
+title: "Tagged"
+date: %time%
+lastmod: %time%
+categories: ["Tag","Other"]
+tags: ["Tag","Other"]
+draft: false
+---
+
+Body text`),
+			true,
+		},
+		// Hashtags below the tag line are collected too.
+		{
+			"inline tags",
+			"inline.md",
+			note{
+				ID:      "INLINE-1",
+				Title:   "Inline",
+				Tagged:  true,
+				BodyRaw: []byte("# Inline\n#blog/tag\n\nMore on #blog/other and #blog/tag"),
+			},
+			[]byte("---\ntitle: \"Inline\"\ndate: %time%\nlastmod: %time%\ncategories: [\"Tag\",\"Other\"]\ntags: [\"Tag\",\"Other\"]\ndraft: false\n---\n\nMore on #blog/other and #blog/tag"),
+			true,
+		},
+		// Tags can be at the end of the note.
+		{
+			"tags at the end",
+			"tags-at-the-end.md",
+			note{
+				ID:      "END-1",
+				Title:   "Tags at the End",
+				Tagged:  true,
+				BodyRaw: []byte("# Tags at the End\n\nBody text\n\n#blog/tag"),
+			},
+			[]byte("---\ntitle: \"Tags at the End\"\ndate: %time%\nlastmod: %time%\ncategories: [\"Tag\"]\ntags: [\"Tag\"]\ndraft: false\n---\n\nBody text"),
+			true,
+		},
+		// Code is exported exactly as it was written.
+		{
+			"code",
+			"code.md",
+			note{
+				ID:      "CODE-1",
+				Title:   "Code",
+				Tagged:  true,
+				BodyRaw: []byte("# Code\n#blog/tag\n\n“Quoted” and `“quoted”`\n```\nfmt.Println(“quoted”)\n```"),
+			},
+			[]byte("---\ntitle: \"Code\"\ndate: %time%\nlastmod: %time%\ncategories: [\"Tag\"]\ntags: [\"Tag\"]\ndraft: false\n---\n\n\"Quoted\" and `“quoted”`\n```\nfmt.Println(“quoted”)\n```"),
+			true,
+		},
+		// Should preserve custom front matter of an existing note, updating its keys where they are.
 		{
 			"existing note",
 			"existing.md",
 			note{
-				Title: "Existing",
+				ID:     "EXISTING-1",
+				Title:  "Existing",
+				Tagged: true,
 				BodyRaw: []byte(`# Existing
 #blog/tag
 
 Updated text`)},
 			[]byte(`---
 title: "Existing"
-date: %time%
+date: 2019-04-29T07:55:21-07:00
+draft: false
 categories: ["Tag"]
 tags: ["Tag"]
-draft: false
 custom: abc
+lastmod: %time%
 ---
 
+Updated text`),
+			false,
+		},
+		// Should keep the front matter format of an existing note.
+		{
+			"existing toml note",
+			"existing-toml.md",
+			note{
+				ID:     "EXISTING-2",
+				Title:  "Existing TOML",
+				Tagged: true,
+				BodyRaw: []byte(`# Existing TOML
+#blog/tag
+
+Updated text`)},
+			[]byte(`+++
+title = "Existing TOML"
+date = 2019-04-29T07:55:21-07:00
+draft = false
+categories = ["Tag"]
+custom = "abc"
+lastmod = %time%
+tags = ["Tag"]
+
+[params]
+tags = ["abc"]
++++
+
 Updated text`),
 			false,
 		},
@@ -75,11 +177,12 @@ Updated text`),
 	contentDir := "content"
 	imageDir := "/"
 
-	done := make(chan bool, 2)
-	notes := make(chan note, 1)
-
-	tmpl, err := template.New("Note Template").Parse(templateRaw)
-	require.NoError(t, err)
+	site := hugoTest{
+		dir:    hugoDir,
+		routes: []route{{Tag: tag, ContentDir: contentDir, ImageDir: imageDir, Categories: true, Tags: true}},
+		now:    tp,
+		format: tf,
+	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -90,7 +193,7 @@ Updated text`),
 
 			defer func() {
 				if test.cleanup {
-					err = os.Remove(dir)
+					err := os.Remove(dir)
 					require.NoError(t, err)
 				} else {
 					err := ioutil.WriteFile(dir, orig, 0666)
@@ -98,28 +201,779 @@ Updated text`),
 				}
 			}()
 
+			runUpdateHugo(t, site, test.in)
+
+			f, err := ioutil.ReadFile(dir)
+			require.NoError(t, err)
+
+			// Replace the date placeholders with the dummy timestamp.
+			exp := bytes.Replace(test.exp, []byte("%time%"), []byte(tp().Format(tf)), -1)
+
+			require.Equal(t, string(exp), string(f))
+		})
+	}
+}
+
+func TestUpdateHugoFrontMatterOrder(t *testing.T) {
+	hugoDir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(hugoDir)
+
+	fp := filepath.Join(hugoDir, "content", "post.md")
+	require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0755))
+	require.NoError(t, ioutil.WriteFile(fp, []byte(`---
+title: "Old Title"
+# Added by hand
+author: Me
+date: 2019-04-29T07:55:21-07:00
+categories: ["Old"]
+draft: true
+aliases:
+  - /old/
+---
+
+Old text`), 0666))
+
+	now := time.Now()
+	runUpdateHugo(t, hugoTest{dir: hugoDir, routes: []route{{Tag: "blog", ContentDir: "content", ImageDir: "/", Categories: true}}, now: func() time.Time { return now }},
+		note{ID: "POST", Title: "Post", Tagged: true, BodyRaw: []byte("# Post\n#blog/new\n\nNew text")})
+
+	f, err := ioutil.ReadFile(fp)
+	require.NoError(t, err)
+	require.Equal(t, `---
+title: "Post"
+# Added by hand
+author: Me
+date: 2019-04-29T07:55:21-07:00
+categories: ["New"]
+draft: false
+aliases:
+  - /old/
+lastmod: `+now.Format(time.RFC3339)+`
+---
+
+New text`, string(f))
+}
+
+func TestUpdateHugoConvertFrontMatter(t *testing.T) {
+	hugoDir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(hugoDir)
+
+	fp := filepath.Join(hugoDir, "content", "post.md")
+	require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0755))
+	require.NoError(t, ioutil.WriteFile(fp, []byte(`+++
+title = "Post"
+date = "2019-04-29"
+# Added by hand
+author = 'Me "Myself"'
+aliases = ["/old/"]
+draft = false
++++
+
+Old text`), 0666))
+
+	now := time.Now()
+	runUpdateHugo(t, hugoTest{dir: hugoDir, routes: []route{{Tag: "blog", ContentDir: "content", ImageDir: "/", Format: formatJSON}}, now: func() time.Time { return now }},
+		note{ID: "POST", Title: "Post", Tagged: true, BodyRaw: []byte("# Post\n#blog\n\nNew text")})
+
+	f, err := ioutil.ReadFile(fp)
+	require.NoError(t, err)
+	require.Equal(t, `{
+  "title": "Post",
+  "date": "2019-04-29",
+  "lastmod": "`+now.Format(time.RFC3339)+`",
+  "draft": false,
+  "author": "Me \"Myself\"",
+  "aliases": ["/old/"]
+}
+
+New text`, string(f))
+}
+
+func TestUpdateHugoNoteIDs(t *testing.T) {
+	hugoDir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(hugoDir)
+
+	contentDir := "content"
+	require.NoError(t, os.MkdirAll(filepath.Join(hugoDir, contentDir), 0755))
+
+	st, err := loadState(filepath.Join(hugoDir, ".bhugo-state.json"))
+	require.NoError(t, err)
+
+	site := hugoTest{dir: hugoDir, routes: []route{{Tag: "blog", ContentDir: contentDir, ImageDir: "/", Categories: true}}, state: st}
+	export := func(n note) {
+		runUpdateHugo(t, site, n)
+	}
+
+	read := func(name string) string {
+		f, err := ioutil.ReadFile(filepath.Join(hugoDir, contentDir, name))
+		require.NoError(t, err)
+		return string(f)
+	}
+
+	export(note{ID: "AAAA-1", Title: "First", Tagged: true, BodyRaw: []byte("# First\n#blog\n\nFirst body")})
+
+	// Add custom front matter which should follow the note when it is renamed.
+	fp := filepath.Join(hugoDir, contentDir, "first.md")
+	f := strings.Replace(read("first.md"), "draft: false", "draft: false\ncustom: abc", 1)
+	require.NoError(t, ioutil.WriteFile(fp, []byte(f), 0666))
+
+	export(note{ID: "AAAA-1", Title: "Renamed", Tagged: true, BodyRaw: []byte("# Renamed\n#blog\n\nFirst body")})
+
+	_, err = os.Stat(fp)
+	require.True(t, os.IsNotExist(err))
+	require.Contains(t, read("renamed.md"), "custom: abc")
+
+	// A different note with the same title must not overwrite the first.
+	export(note{ID: "BBBB-2", Title: "Renamed", Tagged: true, BodyRaw: []byte("# Renamed\n#blog\n\nSecond body")})
+
+	require.Contains(t, read("renamed.md"), "First body")
+	require.Contains(t, read("renamed-bbbb.md"), "Second body")
+
+	// The mapping survives a restart.
+	st, err = loadState(filepath.Join(hugoDir, ".bhugo-state.json"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]noteState{
+		"AAAA-1": {Title: "Renamed", Path: filepath.Join(contentDir, "renamed.md")},
+		"BBBB-2": {Title: "Renamed", Path: filepath.Join(contentDir, "renamed-bbbb.md")},
+	}, st.Notes)
+}
+
+func TestUpdateHugoSlugs(t *testing.T) {
+	hugoDir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(hugoDir)
+
+	contentDir := "content"
+	require.NoError(t, os.MkdirAll(filepath.Join(hugoDir, contentDir), 0755))
+
+	st := &state{Notes: map[string]noteState{}}
+	runUpdateHugo(t, hugoTest{dir: hugoDir, routes: []route{{Tag: "blog", ContentDir: contentDir, ImageDir: "/", Categories: true}}, state: st},
+		note{ID: "AAAA-1", Title: "Café", Tagged: true, BodyRaw: []byte("# Café\n#blog\n\nFirst")},
+		note{ID: "BBBB-2", Title: "Cafe", Tagged: true, BodyRaw: []byte("# Cafe\n#blog\n\nSecond")},
+		note{ID: "BBBB-3", Title: "Cafe?", Tagged: true, BodyRaw: []byte("# Cafe?\n#blog\n\nThird")},
+		note{ID: "CCCC-4", Title: "Chosen", Tagged: true, BodyRaw: []byte("# Chosen\n#blog\nslug: My Slug\n\nFourth")},
+		note{ID: "DDDD-5", Title: "???", Tagged: true, BodyRaw: []byte("# ???\n#blog\n\nFifth")},
+	)
+
+	for id, exp := range map[string]string{
+		"AAAA-1": "cafe.md",
+		"BBBB-2": "cafe-bbbb.md",
+		"BBBB-3": "cafe-bbbb-3.md",
+		"CCCC-4": "my-slug.md",
+		"DDDD-5": "dddd.md",
+	} {
+		ns, ok := st.get(id)
+		require.True(t, ok, id)
+		require.Equal(t, filepath.Join(contentDir, exp), ns.Path, id)
+
+		f, err := ioutil.ReadFile(filepath.Join(hugoDir, contentDir, exp))
+		require.NoError(t, err)
+		require.NotContains(t, string(f), "slug:", id)
+	}
+}
+
+func TestUpdateHugoBundles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	hugoDir := filepath.Join(dir, "site")
+	photo := filepath.Join(dir, "bear", "photo.jpg")
+	require.NoError(t, os.MkdirAll(filepath.Dir(photo), 0755))
+	require.NoError(t, ioutil.WriteFile(photo, []byte("photo"), 0644))
+
+	st := &state{Notes: map[string]noteState{}}
+	site := hugoTest{dir: hugoDir, routes: []route{{Tag: "blog", ContentDir: "content/blog", ImageDir: "/img/posts", Attachments: true, Bundles: true}}, state: st}
+	export := func(n note) {
+		runUpdateHugo(t, site, n)
+	}
+
+	read := func(name string) string {
+		f, err := ioutil.ReadFile(filepath.Join(hugoDir, "content/blog", name))
+		require.NoError(t, err)
+		return string(f)
+	}
+
+	n := note{
+		ID:          "BUNDLE",
+		Title:       "Post",
+		Tagged:      true,
+		Markup:      bear2,
+		BodyRaw:     []byte("# Post\n#blog\n\n![](photo.jpg)\n*A photo*"),
+		Attachments: []attachment{{Name: "photo.jpg", Path: photo}},
+	}
+	export(n)
+
+	require.Contains(t, read("post/index.md"), "\n![A photo](photo.jpg)\n")
+	require.Equal(t, "photo", read("post/photo.jpg"))
+	require.Equal(t, noteState{Title: "Post", Path: "content/blog/post/index.md", Bundle: true}, st.Notes["BUNDLE"])
+
+	// Renaming the note moves the whole bundle, including anything else in it.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(hugoDir, "content/blog/post/extra.txt"), []byte("extra"), 0644))
+	n.Title = "Renamed"
+	n.BodyRaw = []byte("# Renamed\n#blog\n\n![](photo.jpg)\n*A photo*")
+	export(n)
+
+	_, err = os.Stat(filepath.Join(hugoDir, "content/blog/post"))
+	require.True(t, os.IsNotExist(err))
+	require.Contains(t, read("renamed/index.md"), "title: \"Renamed\"")
+	require.Equal(t, "photo", read("renamed/photo.jpg"))
+	require.Equal(t, "extra", read("renamed/extra.txt"))
+
+	// Attachments that aren't copied into the bundle are still linked from the image dir.
+	site.routes[0].Attachments = false
+	n.ID, n.Title = "MANUAL", "Manual"
+	n.BodyRaw = []byte("# Manual\n#blog\n\n![](photo.jpg)")
+	export(n)
+
+	require.Contains(t, read("manual/index.md"), "\n![](/img/posts/photo.jpg)")
+	_, err = os.Stat(filepath.Join(hugoDir, "content/blog/manual/photo.jpg"))
+	require.True(t, os.IsNotExist(err))
+}
+
+// hugoTest is a site for updateHugo to export notes to in tests. Unless it says otherwise
+// posts are dated with time.Now in the local time zone as RFC 3339, and routes use the
+// built in templates as loadRoutes would set them up.
+type hugoTest struct {
+	dir    string
+	routes []route
+	state  *state
+	now    func() time.Time
+	loc    *time.Location
+	format string
+}
+
+// runUpdateHugo exports notes to the site and returns once they've all been written.
+func runUpdateHugo(t *testing.T, site hugoTest, notes ...note) {
+	t.Helper()
+
+	st, now, loc, format := site.state, site.now, site.loc, site.format
+	if st == nil {
+		st = &state{Notes: map[string]noteState{}}
+	}
+	if now == nil {
+		now = time.Now
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	if format == "" {
+		format = time.RFC3339
+	}
+
+	routes := make([]route, len(site.routes))
+	for i, r := range site.routes {
+		if r.tmpl == nil {
+			r.tmpl = template.Must(template.New("Note Template").Funcs(templateFuncs).Parse(templateRaw))
+		}
+		routes[i] = r
+	}
+
+	done := make(chan bool)
+	ch := make(chan note)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go updateHugo(&wg, done, ch, now, loc, format, site.dir, routes, st, removalPolicy{})
+
+	// Neither channel is buffered, so updateHugo has taken the last note before it
+	// can take done, and it finishes each note before it looks at either again.
+	for _, n := range notes {
+		ch <- n
+	}
+	done <- true
+	wg.Wait()
+}
+
+// testBear is a stand-in for the Bear database, created from one of the fixture schemas.
+type testBear struct {
+	*sql.DB
+	t      *testing.T
+	schema schema
+}
+
+func newTestBear(t *testing.T, dsn, fixture string) *testBear {
+	db, err := sql.Connect("sqlite3", dsn)
+	require.NoError(t, err)
+
+	// Every connection to an in-memory database is a new database.
+	db.SetMaxOpenConns(1)
+
+	f, err := ioutil.ReadFile(filepath.Join("testData", "bear", fixture+".sql"))
+	require.NoError(t, err)
+	db.MustExec(string(f))
+
+	s, err := detectSchema(db)
+	require.NoError(t, err)
+
+	return &testBear{db, t, s}
+}
+
+func (b *testBear) source() noteSource {
+	return &sqliteSource{db: b.DB, schema: b.schema}
+}
+
+// write creates or updates a note as Bear would, including its modification date.
+func (b *testBear) write(id, title, text string, modified time.Time) {
+	res := b.MustExec("UPDATE ZSFNOTE SET ZTITLE = ?, ZTEXT = ?, ZMODIFICATIONDATE = ? WHERE ZUNIQUEIDENTIFIER = ?", title, text, toCoreData(modified), id)
+	if n, _ := res.RowsAffected(); n == 0 {
+		b.MustExec("INSERT INTO ZSFNOTE (ZUNIQUEIDENTIFIER, ZTITLE, ZTEXT, ZCREATIONDATE, ZMODIFICATIONDATE) VALUES (?, ?, ?, ?, ?)", id, title, text, toCoreData(modified), toCoreData(modified))
+	}
+
+	// Link the note to its tags and all of their parents like Bear does.
+	var pk int64
+	require.NoError(b.t, b.Get(&pk, "SELECT Z_PK FROM ZSFNOTE WHERE ZUNIQUEIDENTIFIER = ?", id))
+	b.MustExec(fmt.Sprintf("DELETE FROM %s WHERE %s = ?", b.schema.NoteTags, b.schema.NoteTagsNote), pk)
+
+	tags := map[string]bool{}
+	for _, m := range regexp.MustCompile(`#([^\s#]+)`).FindAllStringSubmatch(text, -1) {
+		parts := strings.Split(m[1], "/")
+		for i := range parts {
+			tags[strings.Join(parts[:i+1], "/")] = true
+		}
+	}
+
+	for tag := range tags {
+		var tpk int64
+		if err := b.Get(&tpk, "SELECT Z_PK FROM ZSFNOTETAG WHERE ZTITLE = ?", tag); err != nil {
+			tpk, _ = b.MustExec("INSERT INTO ZSFNOTETAG (ZTITLE) VALUES (?)", tag).LastInsertId()
+		}
+		b.MustExec(fmt.Sprintf("INSERT INTO %s (%s, %s) VALUES (?, ?)", b.schema.NoteTags, b.schema.NoteTagsNote, b.schema.NoteTagsTag), pk, tpk)
+	}
+}
+
+// trash moves a note to the trash without modifying it.
+func (b *testBear) trash(id string, trashed time.Time) {
+	b.MustExec("UPDATE ZSFNOTE SET ZTRASHED = 1, ZTRASHEDDATE = ? WHERE ZUNIQUEIDENTIFIER = ?", toCoreData(trashed), id)
+}
+
+// receive waits for the next count notes from checkBear and returns their IDs.
+func receive(t *testing.T, notes <-chan note, count int) []string {
+	got := []string{}
+	for len(got) < count {
+		select {
+		case n := <-notes:
+			if n.published() {
+				require.Equal(t, noteHash(n.BodyRaw), n.Hash)
+			} else {
+				require.Empty(t, n.Hash)
+			}
+			got = append(got, n.ID)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for notes")
+		}
+	}
+
+	return got
+}
+
+func TestUpdateHugoRoutes(t *testing.T) {
+	hugoDir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(hugoDir)
+
+	routes := []route{
+		{Tag: "blog", ContentDir: "content/blog", ImageDir: "/img/blog", Categories: true, NestedTags: nestedSections},
+		{Tag: "til", ContentDir: "content/til", ImageDir: "/img/til", Tags: true, InlineTags: inlineTagsLink, ShortNotes: shortNotesDraft},
+	}
+	for _, r := range routes {
+		require.NoError(t, os.MkdirAll(filepath.Join(hugoDir, r.ContentDir), 0755))
+	}
+
+	now := time.Now()
+	st := &state{Notes: map[string]noteState{}}
+	runUpdateHugo(t, hugoTest{dir: hugoDir, routes: routes, state: st, now: func() time.Time { return now }},
+		note{ID: "POST", Title: "Post", Tagged: true, BearTags: []string{"blog", "blog/go", "blog/travel", "blog/travel/japan"}, BodyRaw: []byte("# Post\n#blog/go #blog/travel/japan\n\n[image:ABC/post.jpg]\nBody")},
+		note{ID: "TIL", Title: "Learned", Tagged: true, BearTags: []string{"til", "til/go"}, BodyRaw: []byte("# Learned\n#til/go\n\n[image:ABC/til.jpg]\nBody about #til/go")},
+		note{ID: "EMPTY", Title: "Empty", Tagged: true, BearTags: []string{"til"}, BodyRaw: []byte("# Empty\n#til")},
+		note{ID: "SHORT", Title: "Short", Tagged: true, BearTags: []string{"blog"}, BodyRaw: []byte("# Short\n#blog")},
+		note{ID: "OTHER", Title: "Other", Tagged: true, BearTags: []string{"other"}, BodyRaw: []byte("# Other\n#other\n\nBody")},
+	)
+
+	date := now.Format(time.RFC3339)
+	for name, exp := range map[string]string{
+		"content/blog/travel/japan/post.md":   "---\ntitle: \"Post\"\ndate: " + date + "\nlastmod: " + date + "\ncategories: [\"Go\"]\ndraft: false\n---\n\n![](/img/blog/post.jpg)\nBody",
+		"content/blog/travel/_index.md":       "---\ntitle: \"Travel\"\n---\n",
+		"content/blog/travel/japan/_index.md": "---\ntitle: \"Japan\"\n---\n",
+		"content/til/empty.md":                "---\ntitle: \"Empty\"\ndate: " + date + "\nlastmod: " + date + "\ntags: [\"Til\"]\ndraft: true\n---\n",
+		"content/til/learned.md":              "---\ntitle: \"Learned\"\ndate: " + date + "\nlastmod: " + date + "\ntags: [\"Go\"]\ndraft: false\n---\n\n![](/img/til/til.jpg)\nBody about [go](/tags/go/)",
+	} {
+		f, err := ioutil.ReadFile(filepath.Join(hugoDir, name))
+		require.NoError(t, err)
+		require.Equal(t, exp, string(f))
+	}
+
+	for _, id := range []string{"SHORT", "OTHER"} {
+		_, ok := st.get(id)
+		require.False(t, ok)
+	}
+}
+
+func TestCheckBear(t *testing.T) {
+	db := newTestBear(t, ":memory:", "bear1")
+	defer db.Close()
+
+	exported := time.Date(2019, 4, 29, 7, 55, 21, 0, time.UTC)
+
+	db.write("UNCHANGED", "Unchanged", "# Unchanged\n#blog\n\nSame", exported)
+	db.write("CHANGED", "Changed", "# Changed\n#blog\n\nEdited while stopped", exported.Add(time.Hour))
+	db.write("NEW", "New", "# New\n#blog\n\nNew note", exported.Add(time.Hour))
+	db.write("OTHER", "Other", "# Other\n#other\n\nNot for the blog", exported.Add(time.Hour))
+
+	st := &state{Notes: map[string]noteState{
+		"UNCHANGED": {Path: "content/unchanged.md", Hash: noteHash([]byte("# Unchanged\n#blog\n\nSame")), Modified: exported},
+		"CHANGED":   {Path: "content/changed.md", Hash: noteHash([]byte("# Changed\n#blog\n\nOriginal")), Modified: exported},
+	}}
+
+	done := make(chan bool, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	notes := make(chan note, 4)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go checkBear(&wg, done, db.source(), pollDatabase(stop, 10*time.Millisecond), notes, []string{"blog"}, st)
+
+	require.ElementsMatch(t, []string{"CHANGED", "NEW"}, receive(t, notes, 2))
+
+	// Notes are only reloaded once their modification date passes the high water mark.
+	db.write("UNCHANGED", "Unchanged", "# Unchanged\n#blog\n\nNot yet seen", exported)
+	db.write("NEW", "New", "# New\n#blog\n\nEdited", exported.Add(2*time.Hour))
+
+	require.Equal(t, []string{"NEW"}, receive(t, notes, 1))
+
+	// Published notes that are trashed or lose their tag are sent once to be unpublished.
+	db.trash("NEW", exported.Add(3*time.Hour))
+	db.write("CHANGED", "Changed", "# Changed\n#other\n\nMoved off the blog", exported.Add(3*time.Hour))
+
+	require.ElementsMatch(t, []string{"NEW", "CHANGED"}, receive(t, notes, 2))
+
+	db.write("CHANGED", "Changed", "# Changed\n#other\n\nStill off the blog", exported.Add(4*time.Hour))
+	db.write("OTHER", "Other", "# Other\n#other\n\nNever on the blog", exported.Add(4*time.Hour))
+
+	// A later note is only sent once the notes before it have been checked.
+	db.write("LAST", "Last", "# Last\n#blog\n\nLast note", exported.Add(5*time.Hour))
+	require.Equal(t, []string{"LAST"}, receive(t, notes, 1))
+
+	done <- true
+	wg.Wait()
+
+	require.Empty(t, notes)
+}
+
+// loadCounter counts the notes a source loads and can fail to load some of them.
+type loadCounter struct {
+	noteSource
+	mu    sync.Mutex
+	loads map[string]int
+	fail  map[string]bool
+}
+
+func (s *loadCounter) load(n *note) error {
+	s.mu.Lock()
+	s.loads[n.ID]++
+	fail := s.fail[n.ID]
+	s.mu.Unlock()
+
+	if fail {
+		return fmt.Errorf("unable to load %s", n.Title)
+	}
+
+	return s.noteSource.load(n)
+}
+
+func TestUpdateHugoRelink(t *testing.T) {
+	db := newTestBear(t, ":memory:", "bear2")
+	defer db.Close()
+
+	hugoDir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(hugoDir)
+
+	st, err := loadState(filepath.Join(hugoDir, ".bhugo-state.json"))
+	require.NoError(t, err)
+
+	site := hugoTest{dir: hugoDir, routes: []route{{Tag: "blog", ContentDir: "content/blog", ContentRoot: "content", ImageDir: "/"}}, state: st}
+
+	now := time.Now()
+	db.write("LINKER", "Linker", "# Linker\n#blog\n\nSee [[Target]].", now)
+	db.write("TARGET", "Target", "# Target\n\nNot published yet", now)
+
+	done := make(chan bool, 1)
+	changes := make(chan struct{})
+	notes := make(chan note, 1)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go checkBear(&wg, done, db.source(), changes, notes, []string{"blog"}, st)
+
+	// export hands the next note from checkBear to Hugo.
+	export := func(id string) {
+		select {
+		case n := <-notes:
+			require.Equal(t, id, n.ID)
+			runUpdateHugo(t, site, n)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for notes")
+		}
+	}
+
+	read := func() string {
+		f, err := ioutil.ReadFile(filepath.Join(hugoDir, "content/blog/linker.md"))
+		require.NoError(t, err)
+		return string(f)
+	}
+
+	export("LINKER")
+	require.Contains(t, read(), "\nSee Target.")
+
+	// Publishing the note it links to turns the text into a link.
+	db.write("TARGET", "Target", "# Target\n#blog\n\nPublished", now.Add(time.Hour))
+	changes <- struct{}{}
+	export("TARGET")
+	export("LINKER")
+	require.Contains(t, read(), `See [Target]({{< relref "/blog/target.md" >}}).`)
+
+	// Moving it follows it to its new file.
+	db.write("TARGET", "Target", "# Target\n#blog\nslug: moved\n\nPublished", now.Add(2*time.Hour))
+	changes <- struct{}{}
+	export("TARGET")
+	export("LINKER")
+	require.Contains(t, read(), `See [Target]({{< relref "/blog/moved.md" >}}).`)
+
+	// Unpublishing it turns the link back into text, so Hugo doesn't fail on it.
+	db.trash("TARGET", now.Add(3*time.Hour))
+	changes <- struct{}{}
+	export("TARGET")
+	export("LINKER")
+	require.Contains(t, read(), "\nSee Target.")
+
+	done <- true
+	wg.Wait()
+
+	ns, _ := st.get("LINKER")
+	require.False(t, ns.Relink)
+	require.Empty(t, notes)
+}
+
+func TestCheckBearMark(t *testing.T) {
+	db := newTestBear(t, ":memory:", "bear1")
+	defer db.Close()
+
+	// Bear's timestamps have fractions of a second that come back a little
+	// earlier if they're made into a time and back again.
+	modified := 578304921.987656
+	require.True(t, toCoreData(fromCoreData(modified)) < modified)
+
+	db.write("BROKEN", "Broken", "# Broken\n#blog\n\nBody", fromCoreData(modified-60))
+	db.write("NEWEST", "Newest", "# Newest\n#blog\n\nBody", fromCoreData(modified))
+	db.MustExec("UPDATE ZSFNOTE SET ZMODIFICATIONDATE = ? WHERE ZUNIQUEIDENTIFIER = ?", modified, "NEWEST")
+
+	src := &loadCounter{noteSource: db.source(), loads: map[string]int{}, fail: map[string]bool{"BROKEN": true}}
+	done := make(chan bool, 1)
+	changes := make(chan struct{})
+	notes := make(chan note, 2)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go checkBear(&wg, done, src, changes, notes, []string{"blog"}, &state{Notes: map[string]noteState{}})
+
+	require.Equal(t, []string{"NEWEST"}, receive(t, notes, 1))
+
+	// The mark waits for the note that failed, which is loaded again on the next check.
+	src.mu.Lock()
+	src.fail = map[string]bool{}
+	src.mu.Unlock()
+
+	changes <- struct{}{}
+	require.Equal(t, []string{"BROKEN"}, receive(t, notes, 1))
+
+	// Once both are handled neither is loaded again. Each change is only taken once
+	// the check before it has finished.
+	changes <- struct{}{}
+	changes <- struct{}{}
+
+	done <- true
+	wg.Wait()
+
+	require.Equal(t, map[string]int{"BROKEN": 2, "NEWEST": 2}, src.loads)
+	require.Empty(t, notes)
+}
+
+func TestCheckBearTags(t *testing.T) {
+	db := newTestBear(t, ":memory:", "bear1")
+	defer db.Close()
+
+	now := time.Now()
+	db.write("EXACT", "Exact", "# Exact\n#blog\n\nBody", now)
+	db.write("NESTED", "Nested", "# Nested\n#blog/travel/japan #photos\n\nBody", now)
+	db.write("CASE", "Case", "# Case\n#Blog\n\nBody", now)
+	db.write("PREFIX", "Prefix", "# Prefix\n#blogging\n\nBody", now)
+	db.write("QUOTE", "Quote", "# Quote\n#it's\n\nBody", now)
+	db.write("LIKE", "Like", "# Like\n#blog_\n\nBody", now)
+
+	tests := []struct {
+		tag string
+		exp map[string][]string
+	}{
+		{
+			"blog",
+			map[string][]string{
+				"EXACT":  {"blog"},
+				"NESTED": {"blog", "blog/travel", "blog/travel/japan", "photos"},
+				"CASE":   {"Blog"},
+			},
+		},
+		{"it's", map[string][]string{"QUOTE": {"it's"}}},
+		{"blog_", map[string][]string{"LIKE": {"blog_"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.tag, func(t *testing.T) {
+			done := make(chan bool, 1)
+			stop := make(chan struct{})
+			defer close(stop)
+			notes := make(chan note, len(test.exp))
+
 			wg := sync.WaitGroup{}
 			wg.Add(1)
-			go updateHugo(&wg, done, notes, tp, tf, tag, hugoDir, contentDir, imageDir, tmpl, true, true)
-			notes <- test.in
+			go checkBear(&wg, done, db.source(), stop, notes, []string{test.tag}, &state{Notes: map[string]noteState{}})
 
-			// Pause for a moment to make sure the note is processed before the done channel.
-			time.Sleep(time.Millisecond)
+			got := map[string][]string{}
+			for len(got) < len(test.exp) {
+				select {
+				case n := <-notes:
+					got[n.ID] = n.BearTags
+				case <-time.After(time.Second):
+					t.Fatal("timed out waiting for notes")
+				}
+			}
 
 			done <- true
 			wg.Wait()
 
-			f, err := ioutil.ReadFile(dir)
+			require.Equal(t, test.exp, got)
+			require.Empty(t, notes)
+		})
+	}
+}
+
+func TestBearVersions(t *testing.T) {
+	tests := []struct {
+		fixture string
+		text    string
+	}{
+		{
+			"bear1",
+			`# Versions
+#blog/photos
+
+[image:7BD34BA7-1D41-4634-B42B-0C6D20B88E33-34561-0000B3447A4CA4D0/img.jpg]
+*Caption*`,
+		},
+		{
+			"bear2",
+			`# Versions
+#blog/photos
+
+![](img.jpg)<!-- {"width":300} -->
+*Caption*`,
+		},
+	}
+
+	exp := `---
+title: "Versions"
+date: 2019-04-29T00:55:21-07:00
+lastmod: 2019-05-01T00:55:21-07:00
+categories: ["Photos"]
+draft: false
+---
+
+![Caption](/img/posts/img.jpg)
+*Caption*`
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			db := newTestBear(t, ":memory:", test.fixture)
+			defer db.Close()
+
+			hugoDir, err := ioutil.TempDir("", "bhugo")
 			require.NoError(t, err)
+			defer os.RemoveAll(hugoDir)
+			require.NoError(t, os.MkdirAll(filepath.Join(hugoDir, "content"), 0755))
 
-			// Replace the date placeholder with the dummy timestamp.
-			exp := bytes.Replace(test.exp, []byte("%time%"), []byte(tp().Format(tf)), 1)
+			// Bear's dates are written in the configured timezone.
+			created := time.Date(2019, 4, 29, 7, 55, 21, 0, time.UTC)
+			db.write("VERSIONS", "Versions", "", created)
+			db.write("VERSIONS", "Versions", test.text, created.Add(48*time.Hour))
+			pdt := time.FixedZone("PDT", -7*60*60)
 
-			require.Equal(t, string(exp), string(f))
+			st := &state{Notes: map[string]noteState{}}
+			done := make(chan bool, 1)
+			stop := make(chan struct{})
+			defer close(stop)
+			notes := make(chan note, 1)
+
+			wg := sync.WaitGroup{}
+			wg.Add(1)
+			go checkBear(&wg, done, db.source(), stop, notes, []string{"blog"}, st)
+
+			var n note
+			select {
+			case n = <-notes:
+			case <-time.After(time.Second):
+				t.Fatal("timed out waiting for notes")
+			}
+
+			done <- true
+			wg.Wait()
+
+			runUpdateHugo(t, hugoTest{dir: hugoDir, routes: []route{{Tag: "blog", ContentDir: "content", ImageDir: "/img/posts", Categories: true}}, state: st, loc: pdt}, n)
+
+			f, err := ioutil.ReadFile(filepath.Join(hugoDir, "content", "versions.md"))
+			require.NoError(t, err)
+			require.Equal(t, exp, string(f))
 		})
 	}
 }
 
+func TestCheckBearWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Bear keeps its database in write-ahead log mode.
+	database := filepath.Join(dir, "database.sqlite")
+	db := newTestBear(t, database+"?_journal_mode=WAL", "bear2")
+	defer db.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	changes, err := watchDatabase(stop, database, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	done := make(chan bool, 1)
+	notes := make(chan note, 1)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go checkBear(&wg, done, db.source(), changes, notes, []string{"blog"}, &state{Notes: map[string]noteState{}})
+
+	// Nothing is polled, so the note is only found through the write to the database.
+	db.write("NOTE", "Note", "# Note\n#blog\n\nBody", time.Now())
+	require.Equal(t, []string{"NOTE"}, receive(t, notes, 1))
+
+	db.write("NOTE", "Note", "# Note\n#blog\n\nEdited", time.Now())
+	require.Equal(t, []string{"NOTE"}, receive(t, notes, 1))
+
+	done <- true
+	wg.Wait()
+}
+
 func TestScanTags(t *testing.T) {
 	tests := []struct {
 		name string
@@ -199,7 +1053,81 @@ func TestParseImages(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			parseImages(test.in, "/img/posts")
+			parseImages(parseDocument(test.in), imageWriter{dir: "/img/posts"})
+			for i, l := range test.exp {
+				require.Equal(t, string(l), string(test.in[i]))
+			}
+		})
+	}
+}
+
+func TestParseImagesBear2(t *testing.T) {
+	tests := []struct {
+		name string
+		in   [][]byte
+		exp  [][]byte
+	}{
+		{"empty", nil, nil},
+		{
+			"basic",
+			[][]byte{
+				[]byte(`![](img.jpg)<!-- {"width":300} -->`),
+				[]byte("*Caption*"),
+			},
+			[][]byte{
+				[]byte("![Caption](/img/posts/img.jpg)"),
+				[]byte("*Caption*"),
+			},
+		},
+		{
+			"no caption",
+			[][]byte{
+				[]byte("![](My%20Image.png)"),
+				[]byte(""),
+			},
+			[][]byte{
+				[]byte("![](/img/posts/My%20Image.png)"),
+				[]byte(""),
+			},
+		},
+		{
+			"alt text",
+			[][]byte{
+				[]byte(`![A cat](cat.png) <!-- {"width":"fill"} -->`),
+				[]byte("*Not a caption*"),
+			},
+			[][]byte{
+				[]byte("![A cat](/img/posts/cat.png)"),
+				[]byte("*Not a caption*"),
+			},
+		},
+		{
+			"web image",
+			[][]byte{
+				[]byte(`![Logo](https://example.com/logo.png)<!-- {"width":100} -->`),
+			},
+			[][]byte{
+				[]byte("![Logo](https://example.com/logo.png)"),
+			},
+		},
+		{
+			"images on consecutive lines",
+			[][]byte{
+				[]byte("![](one.png)"),
+				[]byte("![](two.png)"),
+				[]byte("*Two*"),
+			},
+			[][]byte{
+				[]byte("![](/img/posts/one.png)"),
+				[]byte("![Two](/img/posts/two.png)"),
+				[]byte("*Two*"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parseImagesBear2(parseDocument(test.in), imageWriter{dir: "/img/posts"})
 			for i, l := range test.exp {
 				require.Equal(t, string(l), string(test.in[i]))
 			}
@@ -259,8 +1187,27 @@ Body Text`),
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			got := customFrontMatter(test.in)
-			require.Equal(t, test.exp, got)
+			d, _, _ := readFrontMatter(test.in)
+			require.Equal(t, test.exp, d.custom(bhugoFrontMatter))
 		})
 	}
 }
+
+func TestFrontMatterValue(t *testing.T) {
+	f := []byte(`---
+title: "Existing"
+date: 2019-04-29T07:55:21-07:00
+draft: false
+---
+
+date: not front matter`)
+
+	d, _, ok := readFrontMatter(f)
+	require.True(t, ok)
+	require.Equal(t, "2019-04-29T07:55:21-07:00", d.value("date"))
+	require.Equal(t, `"Existing"`, d.value("title"))
+	require.Equal(t, "", d.value("lastmod"))
+
+	_, _, ok = readFrontMatter([]byte("date: 2019-04-29"))
+	require.False(t, ok)
+}
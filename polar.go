@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Bear 1 notes are written in Bear's own Polar markup unless markdown
+// compatibility mode is turned on. Polar's inline styles are converted to
+// their CommonMark equivalents, or to HTML where CommonMark has none, which
+// Hugo only renders with markup.goldmark.renderer.unsafe turned on.
+var polarStyles = []struct {
+	delim, open, close string
+}{
+	{"*", "**", "**"},
+	{"/", "*", "*"},
+	{"~", "~~", "~~"},
+	{"_", "<u>", "</u>"},
+	{"::", "<mark>", "</mark>"},
+}
+
+// polarProtected matches the parts of a line that are never styled: links, Bear attachments and URLs.
+var polarProtected = regexp.MustCompile(`!?\[[^\]]*\]\([^)]*\)|\[(?:image|file):[^\]]*\]|\w+://\S+`)
+
+// polarSeparator matches a line separator, which would otherwise look like a todo item.
+var polarSeparator = regexp.MustCompile(`^\s*(-\s*){3,}$`)
+
+// convertPolar converts a Polar note to CommonMark, leaving code untouched.
+func convertPolar(d document) {
+	for i, l := range d.lines {
+		if d.code[i] {
+			continue
+		}
+
+		s := string(l)
+		indent := s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+		s = s[len(indent):]
+
+		// In Polar - is a todo item and + is a completed one.
+		switch {
+		case polarSeparator.MatchString(s):
+		case strings.HasPrefix(s, "- "):
+			s = "- [ ] " + s[2:]
+		case strings.HasPrefix(s, "+ "):
+			s = "- [x] " + s[2:]
+		}
+
+		// Only style the text between the protected parts of the line.
+		styled := inlineProse([]byte(s), func(p []byte) []byte {
+			b := &bytes.Buffer{}
+			last := 0
+			for _, m := range polarProtected.FindAllIndex(p, -1) {
+				b.WriteString(polarInline(string(p[last:m[0]])))
+				b.Write(p[m[0]:m[1]])
+				last = m[1]
+			}
+			b.WriteString(polarInline(string(p[last:])))
+
+			return b.Bytes()
+		})
+
+		d.lines[i] = append([]byte(indent), styled...)
+	}
+}
+
+// polarInline converts the inline styles in some text.
+func polarInline(s string) string {
+	for _, st := range polarStyles {
+		s = polarStyle(s, st.delim, st.open, st.close)
+	}
+
+	return s
+}
+
+// polarStyle replaces a pair of delimiters around some text. Like Bear,
+// the delimiters have to hug the text and sit between words, so that
+// paths like and/or and snake_case aren't styled.
+func polarStyle(s, delim, open, close string) string {
+	b := &strings.Builder{}
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], delim) && polarOpens(s, i, delim) {
+			if j := polarCloses(s, i+len(delim), delim); j >= 0 {
+				b.WriteString(open + s[i+len(delim):j] + close)
+				i = j + len(delim)
+				continue
+			}
+		}
+
+		b.WriteByte(s[i])
+		i++
+	}
+
+	return b.String()
+}
+
+// polarOpens reports whether the delimiter at i can start a style.
+func polarOpens(s string, i int, delim string) bool {
+	if i > 0 && !strings.ContainsRune(" \t([{\"'*~", rune(s[i-1])) {
+		return false
+	}
+
+	next := i + len(delim)
+	return next < len(s) && !isSpace([]byte(s[next:]), false) && !strings.HasPrefix(s[next:], delim)
+}
+
+// polarCloses finds the delimiter that ends a style started before i, or -1 if there isn't one.
+func polarCloses(s string, i int, delim string) int {
+	for j := i + 1; j+len(delim) <= len(s); j++ {
+		if !strings.HasPrefix(s[j:], delim) || isSpace([]byte(s[:j]), true) {
+			continue
+		}
+
+		after := j + len(delim)
+		if after == len(s) || strings.ContainsRune(" \t.,;:!?)]}\"'*~", rune(s[after])) {
+			return j
+		}
+	}
+
+	return -1
+}
+
+// parseFiles replaces Bear 1 file attachments with links to the file in fileDir,
+// in the folder named by its ID that it was copied into if folders is set.
+func parseFiles(d document, fileDir string, folders bool) {
+	d.prose(func(p []byte) []byte {
+		return bear1File.ReplaceAllFunc(p, func(m []byte) []byte {
+			sm := bear1File.FindSubmatch(m)
+			name := string(sm[2])
+			link := strings.Replace(name, " ", "%20", -1)
+			if folders {
+				link = string(sm[1]) + "/" + link
+			}
+			return []byte(fmt.Sprintf("[%s](%s)", name, linkPath(fileDir, link)))
+		})
+	})
+}
+
+// bear1File matches a Bear 1 file attachment, capturing the folder it's in and the file name.
+var bear1File = regexp.MustCompile(`\[file:([^\]/]*)/([^\]]+)\]`)
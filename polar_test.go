@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertPolar(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		exp  string
+	}{
+		{"plain", "Nothing to see here.", "Nothing to see here."},
+		{"bold", "Some *bold* text", "Some **bold** text"},
+		{"italic", "Some /italic words/.", "Some *italic words*."},
+		{"strike", "~gone~ now", "~~gone~~ now"},
+		{"underline", "An _underlined_ word", "An <u>underlined</u> word"},
+		{"highlight", "A ::highlighted:: word", "A <mark>highlighted</mark> word"},
+		{"nested", "*bold /and italic/*", "**bold *and italic***"},
+		{"several", "*one* and *two*", "**one** and **two**"},
+		{"in words", "and/or snake_case 2*3*4", "and/or snake_case 2*3*4"},
+		{"unclosed", "a *b and c", "a *b and c"},
+		{"spaced", "a / b / c", "a / b / c"},
+		{"todo", "- buy milk", "- [ ] buy milk"},
+		{"done", "+ buy *milk*", "- [x] buy **milk**"},
+		{"bullet", "* item", "* item"},
+		{"separator", "- - -", "- - -"},
+		{"inline code", "Run `a /b/ *c*` *now*", "Run `a /b/ *c*` **now**"},
+		{"links", "[/docs/](https://example.com/a_b_c/) /here/", "[/docs/](https://example.com/a_b_c/) *here*"},
+		{"urls", "See https://example.com/_a_/ and /this/", "See https://example.com/_a_/ and *this*"},
+		{"attachments", "[image:ABC/some_file_name.jpg]", "[image:ABC/some_file_name.jpg]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines := [][]byte{[]byte(test.in)}
+			convertPolar(parseDocument(lines))
+			require.Equal(t, test.exp, string(lines[0]))
+		})
+	}
+
+	t.Run("nested todo", func(t *testing.T) {
+		lines := bytes.Split([]byte("- shopping\n\t- milk\n\t+ eggs"), []byte("\n"))
+		convertPolar(parseDocument(lines))
+		require.Equal(t, "- [ ] shopping\n\t- [ ] milk\n\t- [x] eggs", string(bytes.Join(lines, []byte("\n"))))
+	})
+
+	t.Run("code blocks", func(t *testing.T) {
+		lines := bytes.Split([]byte("*a*\n```\n*b*\n- c\n```\n*d*"), []byte("\n"))
+		convertPolar(parseDocument(lines))
+		require.Equal(t, "**a**\n```\n*b*\n- c\n```\n**d**", string(bytes.Join(lines, []byte("\n"))))
+	})
+}
+
+func TestParseFiles(t *testing.T) {
+	lines := [][]byte{[]byte("See [file:ABC-123/Some Report.pdf] and [file:DEF/notes.txt]"), []byte("[image:ABC/img.jpg]")}
+	parseFiles(parseDocument(lines), "/files", false)
+	require.Equal(t, "See [Some Report.pdf](/files/Some%20Report.pdf) and [notes.txt](/files/notes.txt)", string(lines[0]))
+	require.Equal(t, "[image:ABC/img.jpg]", string(lines[1]))
+
+	lines = [][]byte{[]byte("See [file:ABC-123/Some Report.pdf]")}
+	parseFiles(parseDocument(lines), "/files", true)
+	require.Equal(t, "See [Some Report.pdf](/files/ABC-123/Some%20Report.pdf)", string(lines[0]))
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/kelseyhightower/envconfig"
+	log "github.com/sirupsen/logrus"
+)
+
+// route sends the notes with a Bear tag to a section of the Hugo site.
+// Routes are configured with ROUTE_<NAME>_ prefixed keys, for example
+// ROUTE_TIL_CONTENT_DIR, and fall back on the top level configuration.
+type route struct {
+	Tag        string
+	ContentDir string `split_words:"true"`
+	ImageDir   string `split_words:"true"`
+	Categories bool
+	Tags       bool
+	// Hugo's content directory relative to the Hugo directory, which the whole site shares.
+	ContentRoot string `ignored:"true"`
+	// Whether to copy the note's attachments into the image dir under the site's static files.
+	Attachments bool `envconfig:"COPY_ATTACHMENTS"`
+	// Whether to write each note as a leaf bundle, <name>/index.md, with its attachments beside it.
+	Bundles bool `envconfig:"PAGE_BUNDLES"`
+	// How tags nested under the route's tag are used, see nestedFlat and nestedSections.
+	NestedTags string `split_words:"true"`
+	// What becomes of hashtags in the body, see inlineTagsRemove, inlineTagsText and inlineTagsLink.
+	InlineTags string `split_words:"true"`
+	// What to do with notes that have no body, see shortNotesDraft, shortNotesSkip and shortNotesError.
+	ShortNotes string `split_words:"true"`
+	// Smart punctuation to replace, see defaultPunctuation.
+	Punctuation punctuation `envconfig:"SMART_PUNCTUATION"`
+	// How images are processed as they're copied, see imagePipeline.
+	Images imagePipeline `envconfig:"IMAGES"`
+	// Front matter format, see formatYAML, formatTOML and formatJSON, otherwise
+	// it's the format of the existing post or the site.
+	Format string `envconfig:"FRONT_MATTER_FORMAT"`
+	// Path to a template for the route's notes, otherwise the built in template is used.
+	// Templates are executed with a note and can use templateFuncs.
+	Template string
+
+	tmpl    *template.Template
+	imgTmpl *template.Template
+	// Front matter format the route's own template writes, if it has one.
+	tmplFormat string
+}
+
+const (
+	// Nested tags become categories or tags, so #blog/travel/japan is "Travel/Japan".
+	nestedFlat = "flat"
+	// Nested tags choose a section, so #blog/travel/japan is written to travel/japan/ in the content directory.
+	nestedSections = "sections"
+)
+
+// loadRoutes configures a route for each name, or the single default route if there are none.
+func loadRoutes(names []string, defaults route) ([]route, error) {
+	// An empty ROUTES key is a list with one empty name.
+	named := []string{}
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			named = append(named, name)
+		}
+	}
+	if len(named) == 0 {
+		named = []string{defaults.Tag}
+	}
+
+	routes := []route{}
+	for _, name := range named {
+		r := defaults
+		r.Tag = name
+
+		prefix := "ROUTE_" + strings.ToUpper(strings.NewReplacer("/", "_", "-", "_", " ", "_").Replace(name))
+		if err := envconfig.Process(prefix, &r); err != nil {
+			return nil, err
+		}
+
+		if r.NestedTags != nestedFlat && r.NestedTags != nestedSections {
+			return nil, fmt.Errorf("route #%s: unknown nested tags mode %q, expected %s or %s", r.Tag, r.NestedTags, nestedFlat, nestedSections)
+		}
+
+		switch r.InlineTags {
+		case inlineTagsRemove, inlineTagsText, inlineTagsLink:
+		default:
+			return nil, fmt.Errorf("route #%s: unknown inline tags policy %q, expected %s, %s or %s", r.Tag, r.InlineTags, inlineTagsRemove, inlineTagsText, inlineTagsLink)
+		}
+
+		switch r.ShortNotes {
+		case shortNotesDraft, shortNotesSkip, shortNotesError:
+		default:
+			return nil, fmt.Errorf("route #%s: unknown short notes policy %q, expected %s, %s or %s", r.Tag, r.ShortNotes, shortNotesDraft, shortNotesSkip, shortNotesError)
+		}
+
+		switch r.Format {
+		case "", formatYAML, formatTOML, formatJSON:
+		default:
+			return nil, fmt.Errorf("route #%s: unknown front matter format %q, expected %s, %s or %s", r.Tag, r.Format, formatYAML, formatTOML, formatJSON)
+		}
+
+		switch r.Images.Markup {
+		case "", imageMarkdown, imageFigure, imageSrcset:
+		default:
+			return nil, fmt.Errorf("route #%s: unknown image markup %q, expected %s, %s or %s", r.Tag, r.Images.Markup, imageMarkdown, imageFigure, imageSrcset)
+		}
+
+		if err := r.parseTemplate(); err != nil {
+			return nil, err
+		}
+
+		routes = append(routes, r)
+	}
+
+	return routes, nil
+}
+
+func (r *route) parseTemplate() error {
+	var err error
+	if r.Template == "" {
+		r.tmpl, err = template.New("Note Template").Funcs(templateFuncs).Parse(templateRaw)
+	} else {
+		var b []byte
+		b, err = ioutil.ReadFile(r.Template)
+		if err == nil {
+			r.tmpl, err = template.New(filepath.Base(r.Template)).Funcs(templateFuncs).Parse(string(b))
+			r.tmplFormat = templateFormat(b)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("route #%s: %s", r.Tag, err)
+	}
+
+	// Image templates use other delimiters so they can write Hugo shortcodes.
+	if r.Images.Template != "" {
+		r.imgTmpl, err = template.New(filepath.Base(r.Images.Template)).Delims("[[", "]]").ParseFiles(r.Images.Template)
+		if err != nil {
+			return fmt.Errorf("route #%s: %s", r.Tag, err)
+		}
+	}
+
+	return nil
+}
+
+// templateFormat works out the front matter format a template writes from how it starts,
+// returning nothing if it doesn't start with front matter.
+func templateFormat(b []byte) string {
+	first := bytes.TrimSpace(bytes.SplitN(b, []byte("\n"), 2)[0])
+	switch {
+	case bytes.Equal(first, []byte(frontMatterDelims[formatYAML])):
+		return formatYAML
+	case bytes.Equal(first, []byte(frontMatterDelims[formatTOML])):
+		return formatTOML
+	// Template actions start with braces too.
+	case bytes.HasPrefix(first, []byte("{")) && !bytes.HasPrefix(first, []byte("{{")):
+		return formatJSON
+	}
+
+	return ""
+}
+
+// frontMatterTemplates are the built in templates for front matter formats other than YAML.
+var frontMatterTemplates = map[string]*template.Template{
+	formatTOML: template.Must(template.New("TOML Note Template").Funcs(templateFuncs).Parse(templateTOML)),
+	formatJSON: template.Must(template.New("JSON Note Template").Funcs(templateFuncs).Parse(templateJSON)),
+}
+
+// template is the template for the route's notes with front matter in format.
+// A template of the route's own is used whatever the format.
+func (r route) template(format string) *template.Template {
+	if t, ok := frontMatterTemplates[format]; ok && r.Template == "" {
+		return t
+	}
+
+	return r.tmpl
+}
+
+// frontMatter is the front matter that Bhugo manages for the route's notes.
+func (r route) frontMatter() map[string]bool {
+	fm := make(map[string]bool, len(bhugoFrontMatter))
+	for k, v := range bhugoFrontMatter {
+		fm[k] = v
+	}
+
+	fm["categories"] = r.Categories
+	fm["tags"] = r.Tags
+
+	return fm
+}
+
+// taxonomy is the Hugo taxonomy that the route's hashtags are terms of.
+func (r route) taxonomy() string {
+	switch {
+	case r.Categories:
+		return "categories"
+	case r.Tags:
+		return "tags"
+	}
+
+	return ""
+}
+
+// notePath is where a note named name is written in dir.
+func (r route) notePath(dir, name string) string {
+	if r.Bundles {
+		return filepath.Join(dir, name, "index.md")
+	}
+
+	return filepath.Join(dir, name+".md")
+}
+
+// removeNote removes the file of a note, or its whole bundle.
+func removeNote(p string, bundle bool) error {
+	if bundle {
+		return os.RemoveAll(filepath.Dir(p))
+	}
+
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// linkPath is the link to a file in dir, which is relative to the note when dir is empty.
+func linkPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+
+	return dir + "/" + name
+}
+
+// routeFor finds the first route with a tag that the note has.
+func routeFor(routes []route, tags []string) (route, bool) {
+	for _, r := range routes {
+		for _, t := range tags {
+			if matchesTag(t, r.Tag) {
+				return r, true
+			}
+		}
+	}
+
+	return route{}, false
+}
+
+// routeTags are the Bear tags of all the routes.
+func routeTags(routes []route) []string {
+	tags := []string{}
+	for _, r := range routes {
+		tags = append(tags, r.Tag)
+	}
+
+	return tags
+}
+
+// section picks the tag nested under the route's tag that decides where a note is written,
+// returning the part below the route's tag. The most deeply nested tag wins.
+func (r route) section(tags []string) string {
+	section := ""
+	for _, t := range leafTags(tags) {
+		if !matchesTag(t, r.Tag) || strings.EqualFold(t, r.Tag) {
+			continue
+		}
+
+		// Draft tags mark the post as a draft, they aren't sections.
+		if strings.Contains(strings.ToLower(t), "draft") {
+			continue
+		}
+
+		t = t[len(r.Tag)+1:]
+		if strings.Count(t, "/") > strings.Count(section, "/") || section == "" {
+			section = t
+		}
+	}
+
+	return section
+}
+
+// sectionDir is the directory of a section relative to the content directory.
+func sectionDir(section string) string {
+	dirs := strings.Split(section, "/")
+	for i, d := range dirs {
+		dirs[i] = slugify(d)
+	}
+
+	return filepath.Join(dirs...)
+}
+
+const sectionIndex = `---
+title: %s
+---
+`
+
+// createSections makes the directories of a section, with an _index.md
+// for any of them that don't already have one so Hugo lists them.
+func createSections(hugoDir, contentDir, section string) error {
+	dir := filepath.Join(hugoDir, contentDir)
+	for _, s := range strings.Split(section, "/") {
+		dir = filepath.Join(dir, sectionDir(s))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+
+		index := filepath.Join(dir, "_index.md")
+		if _, err := os.Stat(index); !os.IsNotExist(err) {
+			// Either the section already has an index or it can't be checked.
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		log.Infof("Creating section %s", index)
+		if err := ioutil.WriteFile(index, []byte(fmt.Sprintf(sectionIndex, yamlQuote(strings.Title(strings.TrimSpace(s))))), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
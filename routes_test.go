@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRoutes(t *testing.T) {
+	defaults := route{Tag: "blog", ContentDir: "content/blog", ImageDir: "/img/posts", Categories: true, NestedTags: nestedFlat, InlineTags: inlineTagsText, ShortNotes: shortNotesSkip, Images: imagePipeline{MaxWidth: 2000}}
+
+	t.Run("default", func(t *testing.T) {
+		for _, names := range [][]string{nil, {""}} {
+			routes, err := loadRoutes(names, defaults)
+			require.NoError(t, err)
+			require.Len(t, routes, 1)
+
+			r := routes[0]
+			require.NotNil(t, r.tmpl)
+			r.tmpl = nil
+			require.Equal(t, defaults, r)
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		env := map[string]string{
+			"ROUTE_TIL_CONTENT_DIR":         "content/til",
+			"ROUTE_TIL_TAGS":                "true",
+			"ROUTE_TIL_CATEGORIES":          "false",
+			"ROUTE_TIL_TEMPLATE":            "testData/templates/til.md",
+			"ROUTE_TIL_IMAGES_TEMPLATE":     "testData/templates/image.md",
+			"ROUTE_PROJECTS_TAG":            "blog/projects",
+			"ROUTE_PROJECTS_IMAGE_DIR":      "/img/projects",
+			"ROUTE_PROJECTS_IMAGES_PROCESS": "true",
+			"ROUTE_PROJECTS_IMAGES_WIDTHS":  "480,960",
+		}
+		for k, v := range env {
+			require.NoError(t, os.Setenv(k, v))
+			defer os.Unsetenv(k)
+		}
+
+		routes, err := loadRoutes([]string{"til", "projects"}, defaults)
+		require.NoError(t, err)
+		require.Len(t, routes, 2)
+
+		til := routes[0]
+		require.Equal(t, route{Tag: "til", ContentDir: "content/til", ImageDir: "/img/posts", Tags: true, Template: "testData/templates/til.md"}, route{
+			Tag: til.Tag, ContentDir: til.ContentDir, ImageDir: til.ImageDir, Categories: til.Categories, Tags: til.Tags, Template: til.Template,
+		})
+
+		b := &bytes.Buffer{}
+		require.NoError(t, til.tmpl.Execute(b, note{Title: "Something", Date: "today", Body: "Body"}))
+		require.Equal(t, "---\ntitle: \"TIL: Something\"\ndate: today\n---\nBody\n", b.String())
+
+		b.Reset()
+		require.NoError(t, til.imgTmpl.Execute(b, figure{Src: "cat.png", Alt: "Cat"}))
+		require.Equal(t, `{{< img src="cat.png" alt="Cat" >}}`, b.String())
+
+		projects := routes[1]
+		require.Equal(t, "blog/projects", projects.Tag)
+		require.Equal(t, "content/blog", projects.ContentDir)
+		require.Equal(t, "/img/projects", projects.ImageDir)
+		require.Equal(t, imagePipeline{Process: true, MaxWidth: 2000, Widths: widths{480, 960}}, projects.Images)
+	})
+
+	t.Run("unknown nested tags mode", func(t *testing.T) {
+		require.NoError(t, os.Setenv("ROUTE_BLOG_NESTED_TAGS", "deep"))
+		defer os.Unsetenv("ROUTE_BLOG_NESTED_TAGS")
+
+		_, err := loadRoutes(nil, defaults)
+		require.Error(t, err)
+	})
+
+	t.Run("unknown front matter format", func(t *testing.T) {
+		require.NoError(t, os.Setenv("ROUTE_BLOG_FRONT_MATTER_FORMAT", "xml"))
+		defer os.Unsetenv("ROUTE_BLOG_FRONT_MATTER_FORMAT")
+
+		_, err := loadRoutes(nil, defaults)
+		require.Error(t, err)
+	})
+
+	t.Run("unknown image markup", func(t *testing.T) {
+		require.NoError(t, os.Setenv("ROUTE_BLOG_IMAGES_MARKUP", "picture"))
+		defer os.Unsetenv("ROUTE_BLOG_IMAGES_MARKUP")
+
+		_, err := loadRoutes(nil, defaults)
+		require.Error(t, err)
+	})
+
+	t.Run("missing template", func(t *testing.T) {
+		for _, k := range []string{"ROUTE_BLOG_TEMPLATE", "ROUTE_BLOG_IMAGES_TEMPLATE"} {
+			require.NoError(t, os.Setenv(k, "testData/templates/missing.md"))
+			_, err := loadRoutes(nil, defaults)
+			require.Error(t, err)
+			require.NoError(t, os.Unsetenv(k))
+		}
+	})
+}
+
+func TestRouteFor(t *testing.T) {
+	routes := []route{{Tag: "blog/projects"}, {Tag: "blog"}, {Tag: "til"}}
+
+	tests := []struct {
+		name string
+		in   []string
+		exp  string
+	}{
+		{"no tags", nil, ""},
+		{"other tag", []string{"other"}, ""},
+		{"exact", []string{"til"}, "til"},
+		{"nested", []string{"blog/travel"}, "blog"},
+		{"first route wins", []string{"blog", "blog/projects/bhugo"}, "blog/projects"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, ok := routeFor(routes, test.in)
+			require.Equal(t, test.exp != "", ok)
+			require.Equal(t, test.exp, r.Tag)
+		})
+	}
+}
+
+func TestRouteSection(t *testing.T) {
+	r := route{Tag: "blog"}
+
+	tests := []struct {
+		name string
+		in   []string
+		exp  string
+	}{
+		{"no nested tags", []string{"blog"}, ""},
+		{"nested", []string{"blog", "blog/travel"}, "travel"},
+		{"deepest wins", []string{"blog", "blog/go", "blog/travel", "blog/travel/Japan"}, "travel/Japan"},
+		{"same depth", []string{"blog/travel", "blog/go"}, "go"},
+		{"draft", []string{"blog/draft", "blog/go"}, "go"},
+		{"other tags", []string{"blog", "til/go"}, ""},
+		{"case insensitive", []string{"Blog/Travel"}, "Travel"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.exp, r.section(test.in))
+		})
+	}
+}
+
+func TestCreateSections(t *testing.T) {
+	hugoDir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(hugoDir)
+
+	// An existing index is left alone.
+	travel := filepath.Join(hugoDir, "content/blog/travel")
+	require.NoError(t, os.MkdirAll(travel, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(travel, "_index.md"), []byte("custom"), 0644))
+
+	require.NoError(t, createSections(hugoDir, "content/blog", "travel/South Korea"))
+	require.Equal(t, filepath.Join("travel", "south-korea"), sectionDir("travel/South Korea"))
+
+	f, err := ioutil.ReadFile(filepath.Join(travel, "_index.md"))
+	require.NoError(t, err)
+	require.Equal(t, "custom", string(f))
+
+	f, err = ioutil.ReadFile(filepath.Join(travel, "south-korea", "_index.md"))
+	require.NoError(t, err)
+	require.Equal(t, "---\ntitle: \"South Korea\"\n---\n", string(f))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// slugify makes a title into the name of a file or directory. Compatibility characters,
+// such as full width letters and ligatures, are written as their plain ones first. Latin
+// letters lose their accents, other letters and digits are kept along with their marks,
+// apostrophes are dropped and anything else separates words with a single dash.
+func slugify(s string) string {
+	b := &strings.Builder{}
+	dash := false
+	// Whether the marks after the last letter are part of it rather than accents.
+	marks := false
+	for _, r := range strings.ToLower(norm.NFKC.String(s)) {
+		switch {
+		case r == '\'' || r == '’':
+			continue
+		case unicode.In(r, unicode.Mn, unicode.Mc):
+			if marks {
+				b.WriteRune(r)
+			}
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteString(fold(r))
+			dash = false
+			marks = unicode.IsLetter(r) && !unicode.Is(unicode.Latin, r)
+		default:
+			dash = true
+			marks = false
+		}
+	}
+
+	return b.String()
+}
+
+// fold returns a Latin letter without its accents, by decomposing it into its letter
+// and accents. Letters of other scripts are kept as they are, since their marks can
+// make them a different letter rather than an accented one.
+func fold(r rune) string {
+	if f, ok := latinFolds[r]; ok {
+		return f
+	}
+	if !unicode.Is(unicode.Latin, r) {
+		return string(r)
+	}
+
+	return strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, norm.NFD.String(string(r)))
+}
+
+// latinFolds are the plain letters of lower case Latin letters that don't decompose,
+// such as ligatures and letters with strokes.
+var latinFolds = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ĳ': "ij", 'þ': "th", 'ð': "d", 'đ': "d", 'ħ': "h",
+	'ı': "i", 'ĸ': "k", 'ł': "l", 'ŀ': "l", 'ŉ': "n", 'ŋ': "n", 'ø': "o", 'ſ': "s", 'ŧ': "t",
+}
+
+// slugLine reads a line like "slug: my-post", which a note can have under its title
+// to choose its own slug, returning nil if the line isn't one.
+func slugLine(line []byte) []byte {
+	l := bytes.TrimSpace(line)
+	if len(l) < 5 || !bytes.EqualFold(l[:5], []byte("slug:")) {
+		return nil
+	}
+
+	if v := bytes.TrimSpace(l[5:]); len(v) > 0 {
+		return v
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlugify(t *testing.T) {
+	for title, exp := range map[string]string{
+		"My Great Post":           "my-great-post",
+		" Travel ":                "travel",
+		"already-slug":            "already-slug",
+		"Either/Or":               "either-or",
+		"Why? Because: Reasons!":  "why-because-reasons",
+		"Don't Panic":             "dont-panic",
+		"Don’t Panic":             "dont-panic",
+		"Crème Brûlée":            "creme-brulee",
+		"Cafe\u0301":              "cafe",
+		"Straße & Smørrebrød":     "strasse-smorrebrod",
+		"Œuvre Æsthetics":         "oeuvre-aesthetics",
+		"İstanbul":                "istanbul",
+		"Phạm Thị Hương":          "pham-thi-huong",
+		"Ơn trời":                 "on-troi",
+		"Știință și Țară":         "stiinta-si-tara",
+		"Łódź Đà Nẵng":            "lodz-da-nang",
+		"ガイド 한국어":                 "ガイド-한국어",
+		"日本語のメモ":                  "日本語のメモ",
+		"Привет мир":              "привет-мир",
+		"हिंदी नोट्स":             "हिंदी-नोट्स",
+		"தமிழ்":                   "தமிழ்",
+		"か\u3099いと\u3099":         "がいど",
+		"ｆｕｌｌ ｗｉｄｔｈ":              "full-width",
+		"ﬁle №１":                  "file-no1",
+		"--Dashes -- and__more--": "dashes-and-more",
+		"Version 2.0":             "version-2-0",
+		"???":                     "",
+		"🎉":                       "",
+	} {
+		require.Equal(t, exp, slugify(title), title)
+	}
+}
+
+func TestSlugLine(t *testing.T) {
+	for line, exp := range map[string]string{
+		"slug: my-post":     "my-post",
+		"  SLUG:  My Post ": "My Post",
+		"slug:":             "",
+		"slugs: no":         "",
+		"Slug":              "",
+		"Body":              "",
+	} {
+		require.Equal(t, exp, string(slugLine([]byte(line))), line)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	sql "github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// noteSource is somewhere Bhugo can read Bear notes from.
+type noteSource interface {
+	// changed returns the notes that have changed since the high water mark
+	// with their metadata, and whether they have any of the tags.
+	changed(tags []string, since coreDataTime) ([]note, error)
+
+	// load reads the text and tags of a changed note.
+	load(n *note) error
+}
+
+// sqliteSource reads notes straight from Bear's SQLite database.
+type sqliteSource struct {
+	db     *sql.DB
+	schema schema
+	// Bear's Local Files folder, where it keeps attachments.
+	files string
+}
+
+func (s *sqliteSource) changed(tags []string, since coreDataTime) ([]note, error) {
+	tagged := []string{}
+	args := []interface{}{}
+	for _, t := range tags {
+		tagged = append(tagged, s.schema.taggedQuery())
+		args = append(args, t, nestedTagPattern(t))
+	}
+	args = append(args, since.seconds())
+
+	// Text is only loaded for published notes so trashed, archived and
+	// untagged notes cost no more to check than their metadata. Older notes
+	// come first so they keep their slug when notes with the same one are exported together.
+	q := `SELECT Z_PK, ZUNIQUEIDENTIFIER, ZTITLE, ZCREATIONDATE, ZMODIFICATIONDATE, ZTRASHED, ZARCHIVED,
+		(` + strings.Join(tagged, " OR ") + `) AS TAGGED,
+		MAX(IFNULL(ZMODIFICATIONDATE, 0), IFNULL(ZTRASHEDDATE, 0), IFNULL(ZARCHIVEDDATE, 0)) AS CHANGED
+		FROM ZSFNOTE WHERE CHANGED > ? ORDER BY ZCREATIONDATE, Z_PK`
+
+	notes := []note{}
+	if err := s.db.Select(&notes, q, args...); err != nil {
+		return nil, err
+	}
+
+	for i := range notes {
+		notes[i].Markup = s.schema.Markup
+	}
+
+	return notes, nil
+}
+
+func (s *sqliteSource) load(n *note) error {
+	if err := s.db.Get(&n.BodyRaw, "SELECT ZTEXT FROM ZSFNOTE WHERE Z_PK = ?", n.PK); err != nil {
+		return err
+	}
+
+	if err := s.db.Select(&n.BearTags, s.schema.noteTagsQuery(), n.PK); err != nil {
+		return err
+	}
+
+	return s.loadAttachments(n)
+}
+
+// loadAttachments finds the files of the attachments that the note refers to.
+func (s *sqliteSource) loadAttachments(n *note) error {
+	files := []struct {
+		ID   string `db:"ZUNIQUEIDENTIFIER"`
+		Name string `db:"ZFILENAME"`
+	}{}
+	q := "SELECT IFNULL(ZUNIQUEIDENTIFIER, '') AS ZUNIQUEIDENTIFIER, IFNULL(ZFILENAME, '') AS ZFILENAME FROM ZSFNOTEFILE WHERE ZNOTE = ?"
+	if err := s.db.Select(&files, q, n.PK); err != nil {
+		return err
+	}
+
+	n.Attachments = []attachment{}
+	for _, f := range files {
+		// Bear keeps attachments that have been removed from the note.
+		if f.ID == "" || f.Name == "" || !referenced(n.BodyRaw, f.Name) {
+			continue
+		}
+
+		// Bear 1 refers to attachments by their folder too, which they keep since
+		// Bear names every pasted image image.png.
+		name := f.Name
+		if referenced(n.BodyRaw, f.ID+"/"+f.Name) {
+			name = f.ID + "/" + f.Name
+		}
+
+		found := false
+		for _, d := range bearAttachmentDirs {
+			p := filepath.Join(s.files, d, f.ID, f.Name)
+			if _, err := os.Stat(p); err == nil {
+				n.Attachments = append(n.Attachments, attachment{Name: name, Path: p})
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Warnf("Unable to find %s from %s in %s", f.Name, n.Title, s.files)
+		}
+	}
+
+	return nil
+}
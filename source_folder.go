@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// folderSource reads notes that Bear exported to a folder as Markdown files
+// or TextBundles, so posts can be built without Bear's database.
+type folderSource struct {
+	dir string
+	// Whether notes in Bear 1's markup are in Polar markup.
+	polar bool
+}
+
+// textBundleInfo is the part of a TextBundle's info.json that Bear fills in.
+type textBundleInfo struct {
+	Bear struct {
+		UniqueIdentifier string `json:"uniqueIdentifier"`
+		CreationDate     string `json:"creationDate"`
+		ModificationDate string `json:"modificationDate"`
+	} `json:"net.shinyfrog.bear"`
+}
+
+// changed reads every note exported since the high water mark. Exported notes
+// have to be read to find their tags, so their text is loaded here too.
+func (s *folderSource) changed(tags []string, since coreDataTime) ([]note, error) {
+	notes := []note{}
+
+	err := filepath.Walk(s.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		var n note
+		switch ext := strings.ToLower(filepath.Ext(p)); {
+		case info.IsDir() && ext == ".textbundle":
+			// One bad bundle shouldn't stop the rest of the notes being exported.
+			text, ti, err := bundleText(p)
+			if err != nil {
+				log.Errorf("Unable to read %s, skipping it: %s", p, err)
+				return filepath.SkipDir
+			}
+			if !ti.ModTime().After(since.Time) {
+				return filepath.SkipDir
+			}
+			n, err = s.readTextBundle(p, text, ti)
+			if err != nil {
+				log.Errorf("Unable to read %s, skipping it: %s", p, err)
+				return filepath.SkipDir
+			}
+			notes = append(notes, n)
+			return filepath.SkipDir
+
+		case info.IsDir() || (ext != ".md" && ext != ".markdown"):
+			return nil
+
+		case !info.ModTime().After(since.Time):
+			return nil
+		}
+
+		text, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+
+		n = newFolderNote(filepath.ToSlash(rel), text, info.ModTime())
+		n.Attachments = folderAttachments(filepath.Dir(p), text)
+		notes = append(notes, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, n := range notes {
+		if s.polar && n.Markup == bear1 {
+			notes[i].Markup = polar
+		}
+
+		for _, t := range n.BearTags {
+			for _, tag := range tags {
+				if matchesTag(t, tag) {
+					notes[i].Tagged = true
+				}
+			}
+		}
+	}
+
+	return notes, nil
+}
+
+// load does nothing since the note was read in full when it was found to have changed.
+func (s *folderSource) load(n *note) error {
+	return nil
+}
+
+// bundleText finds the text of a TextBundle, whose modification time is when the note
+// was last changed, so unchanged bundles can be skipped without reading them.
+func bundleText(dir string) (string, os.FileInfo, error) {
+	text := filepath.Join(dir, "text.markdown")
+	info, err := os.Stat(text)
+	if os.IsNotExist(err) {
+		text = filepath.Join(dir, "text.md")
+		info, err = os.Stat(text)
+	}
+
+	return text, info, err
+}
+
+// readTextBundle reads a note from a TextBundle, given its text that bundleText found.
+func (s *folderSource) readTextBundle(dir, text string, info os.FileInfo) (note, error) {
+	b, err := ioutil.ReadFile(text)
+	if err != nil {
+		return note{}, err
+	}
+
+	rel, err := filepath.Rel(s.dir, dir)
+	if err != nil {
+		return note{}, err
+	}
+
+	n := newFolderNote(filepath.ToSlash(rel), b, info.ModTime())
+	n.Attachments = folderAttachments(dir, b)
+
+	// Bear records the note's ID and dates in the bundle's metadata.
+	ib, err := ioutil.ReadFile(filepath.Join(dir, "info.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return note{}, err
+	}
+	if len(ib) > 0 {
+		var tbi textBundleInfo
+		if err := json.Unmarshal(ib, &tbi); err != nil {
+			return note{}, err
+		}
+
+		if tbi.Bear.UniqueIdentifier != "" {
+			n.ID = tbi.Bear.UniqueIdentifier
+		}
+		if t, err := time.Parse(time.RFC3339, tbi.Bear.CreationDate); err == nil {
+			n.Created.Time = t
+		}
+		if t, err := time.Parse(time.RFC3339, tbi.Bear.ModificationDate); err == nil {
+			n.Modified.Time = t
+		}
+	}
+
+	return n, nil
+}
+
+// newFolderNote creates a note from exported text. The note's path within the
+// export folder identifies it unless Bear recorded its ID.
+func newFolderNote(rel string, text []byte, modified time.Time) note {
+	n := note{
+		ID:       rel,
+		BodyRaw:  text,
+		BearTags: textTags(text),
+		Markup:   detectMarkup(text),
+	}
+	n.Modified.Time = modified
+	n.Changed.Time = modified
+
+	// The title is the first heading, otherwise the file name.
+	first := bytes.SplitN(text, []byte("\n"), 2)[0]
+	if bytes.HasPrefix(first, []byte("# ")) {
+		n.Title = string(bytes.TrimSpace(first[2:]))
+	} else {
+		n.Title = strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel))
+	}
+
+	return n
+}
+
+// bear1Attachment matches an image or file in Bear 1's own syntax.
+var bear1Attachment = regexp.MustCompile(`\[(?:image|file):[^\]]+\]`)
+
+// detectMarkup works out which version of Bear wrote an exported note. Bear 1
+// leaves its attachments in its own syntax, otherwise the note is markdown.
+func detectMarkup(text []byte) markup {
+	if bear1Attachment.Match(text) {
+		return bear1
+	}
+
+	return bear2
+}
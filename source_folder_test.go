@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFolderSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"Bundle.textbundle/text.markdown":  "# Bundle\n#blog/photos\n\n![](assets/img.jpg)",
+		"Bundle.textbundle/info.json":      `{"version":2,"type":"net.daringfireball.markdown","net.shinyfrog.bear":{"uniqueIdentifier":"BUNDLE-1","modificationDate":"2019-04-29T07:55:21Z"}}`,
+		"Bundle.textbundle/assets/img.jpg": "jpg",
+		"nested/Markdown.md":               "# Markdown\n#blog\n\nBody",
+		"Bear 1.md":                        "# Bear 1\n#blog\n\n[image:ABC/img.jpg]",
+		"Broken.textbundle/text.markdown":  "# Broken\n#blog\n\nBody",
+		"Broken.textbundle/info.json":      "{",
+		"Untagged.md":                      "# Untagged\n#blogging\n\nBody",
+		"notes.txt":                        "#blog",
+	}
+	for name, text := range files {
+		fp := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0755))
+		require.NoError(t, ioutil.WriteFile(fp, []byte(text), 0666))
+	}
+
+	src := &folderSource{dir: dir}
+	notes, err := src.changed([]string{"blog"}, coreDataTime{})
+	require.NoError(t, err)
+
+	got := map[string]note{}
+	for _, n := range notes {
+		require.NoError(t, src.load(&n))
+		got[n.ID] = n
+	}
+	require.Len(t, got, 4)
+
+	bundle := got["BUNDLE-1"]
+	require.Equal(t, "Bundle", bundle.Title)
+	require.True(t, bundle.published())
+	require.Equal(t, []string{"blog/photos"}, bundle.BearTags)
+	require.Equal(t, bear2, bundle.Markup)
+	require.Equal(t, time.Date(2019, 4, 29, 7, 55, 21, 0, time.UTC), bundle.Modified.Time)
+
+	md := got["nested/Markdown.md"]
+	require.Equal(t, "Markdown", md.Title)
+	require.True(t, md.published())
+	require.Equal(t, "# Markdown\n#blog\n\nBody", string(md.BodyRaw))
+
+	require.Equal(t, bear2, md.Markup)
+
+	require.Equal(t, bear1, got["Bear 1.md"].Markup)
+	require.False(t, got["Untagged.md"].published())
+
+	// Bear 1 notes can be in Polar markup.
+	src.polar = true
+	notes, err = src.changed([]string{"blog"}, coreDataTime{})
+	require.NoError(t, err)
+	for _, n := range notes {
+		if n.ID == "Bear 1.md" {
+			require.Equal(t, polar, n.Markup)
+		}
+	}
+	src.polar = false
+
+	// Nothing has changed since the newest note.
+	notes, err = src.changed([]string{"blog"}, coreDataTime{Time: time.Now().Add(time.Minute)})
+	require.NoError(t, err)
+	require.Empty(t, notes)
+}
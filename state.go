@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// noteState is what Bhugo remembers about a note it has exported.
+type noteState struct {
+	// Title of the note, to resolve links to it.
+	Title string `json:"title"`
+	// Path of the exported file relative to the Hugo directory.
+	Path string `json:"path"`
+	// Whether the file is the index of a page bundle, which belongs to the note too.
+	Bundle bool `json:"bundle"`
+	// Hash of the note text that was last exported.
+	Hash string `json:"hash"`
+	// Bear's modification date of the exported version.
+	Modified time.Time `json:"modified"`
+	// Titles and IDs of the notes it links to.
+	Links []string `json:"links,omitempty"`
+	// Whether a note it links to has changed since it was exported, so its links need resolving again.
+	Relink bool `json:"relink,omitempty"`
+}
+
+// state is the persisted mapping of Bear note IDs to their exported Hugo files.
+// It is shared between goroutines so all access goes through its methods.
+type state struct {
+	mu    sync.Mutex
+	file  string
+	Notes map[string]noteState `json:"notes"`
+	// Images written by the image pipeline, by path, with what they were made from.
+	Images map[string]string `json:"images,omitempty"`
+	// Signalled when notes are marked to be exported again for their links.
+	relinked chan struct{}
+}
+
+// loadState reads the state file, returning an empty state if it doesn't exist yet.
+func loadState(file string) (*state, error) {
+	s := &state{file: file, Notes: make(map[string]noteState), relinked: make(chan struct{}, 1)}
+
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	if s.Notes == nil {
+		s.Notes = make(map[string]noteState)
+	}
+
+	return s, nil
+}
+
+// get returns the state of the note with the given ID.
+func (s *state) get(id string) (noteState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ns, ok := s.Notes[id]
+	return ns, ok
+}
+
+// hashes returns the last exported hash of every note, keyed by note ID.
+func (s *state) hashes() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := make(map[string]string, len(s.Notes))
+	for id, ns := range s.Notes {
+		h[id] = ns.Hash
+	}
+
+	return h
+}
+
+// latest returns the newest modification date of any exported note.
+func (s *state) latest() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var l time.Time
+	for _, ns := range s.Notes {
+		if ns.Modified.After(l) {
+			l = ns.Modified
+		}
+	}
+
+	return l
+}
+
+// owner returns the ID of the note exported to path, if there is one.
+func (s *state) owner(path string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, ns := range s.Notes {
+		if ns.Path == path {
+			return id, true
+		}
+	}
+
+	return "", false
+}
+
+// set records the state of a note and persists the state file.
+func (s *state) set(id string, ns noteState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Notes[id] = ns
+	return s.save()
+}
+
+// image returns what the processed image at path was made from.
+func (s *state) image(path string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Images[path]
+}
+
+// setImage records what the processed image at path was made from and persists the state file.
+func (s *state) setImage(path, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Images == nil {
+		s.Images = make(map[string]string)
+	}
+	s.Images[path] = key
+	return s.save()
+}
+
+// remove forgets a note and persists the state file.
+func (s *state) remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.Notes, id)
+	return s.save()
+}
+
+// save writes the state file atomically so a crash never leaves it half written.
+// The caller must hold the lock.
+func (s *state) save() error {
+	if s.file == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.file), filepath.Base(s.file))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.file)
+}
+
+// noteHash identifies a version of a note's text.
+func noteHash(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// siteStateDir is the directory in the XDG state directory for a Hugo site's state.
+// Each site has its own, named after the site and a hash of its absolute path, so
+// sites with the same name don't share one either.
+func siteStateDir(hugoDir string) (string, error) {
+	abs, err := filepath.Abs(hugoDir)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256([]byte(abs))
+	name := hex.EncodeToString(h[:6])
+	if site := slugify(filepath.Base(abs)); site != "" {
+		name = fmt.Sprintf("%s-%s", site, name)
+	}
+
+	return filepath.Join(xdgStateHome(), "bhugo", name), nil
+}
+
+// xdgStateHome is the base directory for user specific state files.
+func xdgStateHome() string {
+	if d := os.Getenv("XDG_STATE_HOME"); d != "" {
+		return d
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+
+	return filepath.Join(home, ".local", "state")
+}
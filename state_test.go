@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSiteStateDir(t *testing.T) {
+	require.NoError(t, os.Setenv("XDG_STATE_HOME", "/state"))
+	defer os.Unsetenv("XDG_STATE_HOME")
+
+	blog, err := siteStateDir("/sites/blog")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join("/state", "bhugo"), filepath.Dir(blog))
+	require.Regexp(t, `^blog-[0-9a-f]{12}$`, filepath.Base(blog))
+
+	again, err := siteStateDir("/sites/blog/")
+	require.NoError(t, err)
+	require.Equal(t, blog, again)
+
+	other, err := siteStateDir("/other/blog")
+	require.NoError(t, err)
+	require.NotEqual(t, blog, other)
+}
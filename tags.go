@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// hashtag is a Bear tag found in a line of text.
+type hashtag struct {
+	Tag string
+	// Byte offsets of the whole hashtag in the line, including its hashes.
+	Start, End int
+}
+
+// findHashtags finds Bear hashtags in a line. Tags start with a hash after a
+// space or the start of the line and end at the next space, or for multi-word
+// tags at a closing hash. Headings don't count as the hash is followed by a space.
+func findHashtags(l []byte) []hashtag {
+	tags := []hashtag{}
+
+	for i := 0; i < len(l); i++ {
+		if l[i] != '#' || (i > 0 && !isSpace(l[:i], true)) {
+			continue
+		}
+
+		next, _ := utf8.DecodeRune(l[i+1:])
+		if i+1 >= len(l) || next == '#' || unicode.IsSpace(next) {
+			continue
+		}
+
+		end := bytes.IndexFunc(l[i+1:], unicode.IsSpace)
+		if end < 0 {
+			end = len(l)
+		} else {
+			end += i + 1
+		}
+
+		// A closing hash after a space makes this a multi-word tag,
+		// as long as the closing hash isn't the start of another tag.
+		if c := bytes.IndexByte(l[i+1:], '#'); c >= 0 && i+1+c > end {
+			c += i + 1
+			if !isSpace(l[:c], true) && (c+1 == len(l) || isSpace(l[c+1:], false)) {
+				end = c + 1
+			}
+		}
+
+		tag := strings.TrimSuffix(string(l[i+1:end]), "#")
+		tags = append(tags, hashtag{Tag: strings.TrimSpace(tag), Start: i, End: end})
+		i = end - 1
+	}
+
+	return tags
+}
+
+// isSpace reports whether the rune at the end (or start) of b is white space.
+func isSpace(b []byte, last bool) bool {
+	var r rune
+	if last {
+		r, _ = utf8.DecodeLastRune(b)
+	} else {
+		r, _ = utf8.DecodeRune(b)
+	}
+
+	return unicode.IsSpace(r)
+}
+
+// textTags returns every hashtag in the text, ignoring code.
+func textTags(text []byte) []string {
+	tags := []string{}
+	d := parseDocument(bytes.Split(text, []byte("\n")))
+	for i, l := range d.lines {
+		if d.code[i] {
+			continue
+		}
+
+		for _, h := range findHashtags(stripCode(l)) {
+			tags = append(tags, h.Tag)
+		}
+	}
+
+	return tags
+}
+
+// uniqueTags drops repeated tags, ignoring case.
+func uniqueTags(tags []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, t := range tags {
+		if !seen[strings.ToLower(t)] {
+			seen[strings.ToLower(t)] = true
+			unique = append(unique, t)
+		}
+	}
+
+	return unique
+}
+
+// matchesTag reports whether t is tag or nested under it.
+func matchesTag(t, tag string) bool {
+	return strings.EqualFold(t, tag) || strings.HasPrefix(strings.ToLower(t), strings.ToLower(tag)+"/")
+}
+
+// hasTerm reports whether term is one of the terms, ignoring case.
+func hasTerm(terms []string, term string) bool {
+	for _, t := range terms {
+		if strings.EqualFold(t, term) {
+			return true
+		}
+	}
+
+	return false
+}
+
+const (
+	// Inline hashtags are removed from the body.
+	inlineTagsRemove = "remove"
+	// Inline hashtags are left as plain words, so #blog/travel is "travel".
+	inlineTagsText = "text"
+	// Inline hashtags link to their taxonomy term page.
+	inlineTagsLink = "link"
+)
+
+// inlineTags replaces the hashtags in the prose of a document according to the
+// policy, linking to the term pages of taxonomy when there is one. Terms are
+// named the same as in the front matter, using routeTag to format them, and only
+// the terms in the front matter are linked since no other term has a page.
+func (d document) inlineTags(policy, taxonomy, routeTag string, terms []string) {
+	if policy == inlineTagsLink && taxonomy == "" {
+		policy = inlineTagsText
+	}
+
+	for i, l := range d.lines {
+		if d.code[i] {
+			continue
+		}
+
+		l = inlineProse(l, func(p []byte) []byte {
+			tags := findHashtags(p)
+			for j := len(tags) - 1; j >= 0; j-- {
+				h := tags[j]
+				words := h.Tag[strings.LastIndex(h.Tag, "/")+1:]
+
+				var r []byte
+				switch policy {
+				case inlineTagsRemove:
+					// Take the space before the tag with it, or after it at the start of a line.
+					if h.Start > 0 {
+						h.Start--
+					} else if h.End < len(p) {
+						h.End++
+					}
+				case inlineTagsText:
+					r = []byte(words)
+				case inlineTagsLink:
+					term := formatTag([]byte(h.Tag), routeTag)
+					if !hasTerm(terms, term) {
+						r = []byte(words)
+						break
+					}
+					r = []byte(fmt.Sprintf("[%s](/%s/%s/)", words, taxonomy, strings.Replace(strings.ToLower(term), " ", "-", -1)))
+				default:
+					continue
+				}
+
+				p = append(append(append([]byte{}, p[:h.Start]...), r...), p[h.End:]...)
+			}
+
+			return p
+		})
+
+		// A line of nothing but removed tags is left empty.
+		if len(bytes.TrimSpace(l)) == 0 {
+			l = []byte{}
+		}
+		d.lines[i] = l
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindHashtags(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		exp  []hashtag
+	}{
+		{"empty", "", []hashtag{}},
+		{"heading", "# Heading", []hashtag{}},
+		{"one tag", "#blog", []hashtag{{"blog", 0, 5}}},
+		{"nested tag", "text #blog/travel/japan text", []hashtag{{"blog/travel/japan", 5, 23}}},
+		{"multi-word tag", "#blog/multi word# text", []hashtag{{"blog/multi word", 0, 17}}},
+		{
+			"multiple tags",
+			"#blog/abc #blog/def abc#  #def",
+			[]hashtag{{"blog/abc", 0, 9}, {"blog/def abc", 10, 24}, {"def", 26, 30}},
+		},
+		{"not a tag", "issue#4 and # alone", []hashtag{}},
+		{"unicode", "#café #日本", []hashtag{{"café", 0, 6}, {"日本", 7, 14}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.exp, findHashtags([]byte(test.in)))
+		})
+	}
+}
+
+func TestInlineTags(t *testing.T) {
+	in := "#blog/go at the start, #blog/multi word# in the middle `#code` and #other\n#blog/travel/japan #blog\n```\n#include <stdio.h>\n```"
+
+	tests := []struct {
+		name     string
+		policy   string
+		taxonomy string
+		exp      string
+	}{
+		{"remove", inlineTagsRemove, "", "at the start, in the middle `#code` and\n\n```\n#include <stdio.h>\n```"},
+		{"text", inlineTagsText, "", "go at the start, multi word in the middle `#code` and other\njapan blog\n```\n#include <stdio.h>\n```"},
+		{
+			"link",
+			inlineTagsLink,
+			"categories",
+			"[go](/categories/go/) at the start, [multi word](/categories/multi-word/) in the middle `#code` and other\n" +
+				"[japan](/categories/travel/japan/) blog\n```\n#include <stdio.h>\n```",
+		},
+		{"link without taxonomy", inlineTagsLink, "", "go at the start, multi word in the middle `#code` and other\njapan blog\n```\n#include <stdio.h>\n```"},
+		{"unset", "", "", in},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := parseDocument(bytes.Split([]byte(in), []byte("\n")))
+			// Only some of the tags are in the front matter.
+			d.inlineTags(test.policy, test.taxonomy, "blog", []string{"Go", "Multi Word", "Travel/Japan"})
+			require.Equal(t, test.exp, string(d.bytes()))
+		})
+	}
+}
+
+func TestUniqueTags(t *testing.T) {
+	require.Equal(t, []string{"Go", "Travel"}, uniqueTags([]string{"Go", "Travel", "go", "Go"}))
+}
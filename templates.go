@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// templateFuncs are the helpers available to note templates, the built in ones included.
+// Each front matter format quotes strings by its own rules, so each has its own helpers.
+var templateFuncs = template.FuncMap{
+	"slugify":   slugify,
+	"yamlQuote": yamlQuote,
+	"tomlQuote": tomlQuote,
+	"tomlDate":  tomlDate,
+	"jsonQuote": jsonQuote,
+	"yamlList":  yamlList,
+	"tomlList":  tomlList,
+	"jsonList":  jsonList,
+	"truncate":  truncate,
+	"join":      strings.Join,
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"trim":      strings.TrimSpace,
+}
+
+// yamlQuote writes s as a YAML double quoted string. Invalid UTF-8 is written as the
+// replacement character.
+func yamlQuote(s string) string {
+	b := &strings.Builder{}
+	// Strings always encode.
+	_ = yaml.NewEncoder(b).Encode(&yaml.Node{Kind: yaml.ScalarNode, Style: yaml.DoubleQuotedStyle, Value: strings.ToValidUTF8(s, "\uFFFD")})
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// tomlQuote writes s as a TOML basic string. Invalid UTF-8 is written as the replacement
+// character.
+func tomlQuote(s string) string {
+	b := &strings.Builder{}
+	// TOML only encodes tables, so s is encoded as a key's value and the key cut off again.
+	_ = toml.NewEncoder(b).Encode(map[string]string{"s": strings.ToValidUTF8(s, "\uFFFD")})
+	return strings.TrimRight(strings.TrimPrefix(b.String(), "s = "), "\n")
+}
+
+// tomlDateLayouts are the dates and times TOML writes without quotes.
+var tomlDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+	"15:04:05.999999999",
+}
+
+// tomlDate writes s as it is if it's a TOML date, and as a string if it isn't.
+func tomlDate(s string) string {
+	for _, layout := range tomlDateLayouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return s
+		}
+	}
+
+	return tomlQuote(s)
+}
+
+// jsonQuote writes s as a JSON string. Invalid UTF-8 is written as the replacement character.
+func jsonQuote(s string) string {
+	// Strings always encode.
+	v, _ := jsonValue(s)
+	return v
+}
+
+// yamlList writes strings as a YAML flow sequence.
+func yamlList(items []string) string {
+	return quotedList(items, yamlQuote)
+}
+
+// tomlList writes strings as a TOML array.
+func tomlList(items []string) string {
+	return quotedList(items, tomlQuote)
+}
+
+// jsonList writes strings as a JSON array.
+func jsonList(items []string) string {
+	return quotedList(items, jsonQuote)
+}
+
+// quotedList writes strings between brackets, quoted and separated by commas,
+// which is how YAML, TOML and JSON all write a list.
+func quotedList(items []string, quote func(string) string) string {
+	quoted := make([]string, len(items))
+	for i, s := range items {
+		quoted[i] = quote(s)
+	}
+
+	return "[" + strings.Join(quoted, ",") + "]"
+}
+
+// truncate cuts s down to n characters, at the end of a word if there is one,
+// marking that it was cut with an ellipsis. It takes n first so it can be piped to.
+func truncate(n int, s string) string {
+	r := []rune(strings.TrimSpace(s))
+	if n < 0 || len(r) <= n {
+		return string(r)
+	}
+
+	cut := n
+	for i := n; i > 0; i-- {
+		if unicode.IsSpace(r[i]) {
+			cut = i
+			break
+		}
+	}
+
+	return strings.TrimRightFunc(string(r[:cut]), unicode.IsSpace) + "..."
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in               string
+		yaml, toml, json string
+	}{
+		{"Title", `"Title"`, `"Title"`, `"Title"`},
+		{`Say "hi"`, `"Say \"hi\""`, `"Say \"hi\""`, `"Say \"hi\""`},
+		{"Back\\slash", `"Back\\slash"`, `"Back\\slash"`, `"Back\\slash"`},
+		{"Two\nlines\ttabbed", `"Two\nlines\ttabbed"`, `"Two\nlines\ttabbed"`, `"Two\nlines\ttabbed"`},
+		{"<b>&</b>", `"<b>&</b>"`, `"<b>&</b>"`, `"<b>&</b>"`},
+		{"Café", `"Café"`, `"Café"`, `"Café"`},
+		{"\x00\x1b\x7f", `"\0\e\x7F"`, `"\u0000\u001b\u007f"`, "\"\\u0000\\u001b\x7f\""},
+		{"\u0085\u00a0", "\"\\N\u00a0\"", "\"\u0085\u00a0\"", "\"\u0085\u00a0\""},
+		{"Line\u2028break", `"Line\Lbreak"`, "\"Line\u2028break\"", `"Line\u2028break"`},
+		{"Bad \xff byte", `"Bad � byte"`, `"Bad � byte"`, `"Bad � byte"`},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.yaml, yamlQuote(test.in), "yaml %q", test.in)
+		require.Equal(t, test.toml, tomlQuote(test.in), "toml %q", test.in)
+		require.Equal(t, test.json, jsonQuote(test.in), "json %q", test.in)
+	}
+}
+
+func TestList(t *testing.T) {
+	for _, list := range []func([]string) string{yamlList, tomlList, jsonList} {
+		require.Equal(t, "[]", list(nil))
+		require.Equal(t, `["Go"]`, list([]string{"Go"}))
+		require.Equal(t, `["Go","Say \"hi\""]`, list([]string{"Go", `Say "hi"`}))
+	}
+	require.Equal(t, `["\e"]`, yamlList([]string{"\x1b"}))
+	require.Equal(t, `["\u001b"]`, tomlList([]string{"\x1b"}))
+	require.Equal(t, `["\u001b"]`, jsonList([]string{"\x1b"}))
+}
+
+func TestTOMLDate(t *testing.T) {
+	tests := []struct {
+		in, exp string
+	}{
+		{"2019-04-29T10:00:00Z", "2019-04-29T10:00:00Z"},
+		{"2019-04-29T10:00:00.5+02:00", "2019-04-29T10:00:00.5+02:00"},
+		{"2019-04-29T10:00:00", "2019-04-29T10:00:00"},
+		{"2019-04-29", "2019-04-29"},
+		{"10:00:00", "10:00:00"},
+		{"2019-04-29 10:00", `"2019-04-29 10:00"`},
+		{"April 29", `"April 29"`},
+		{"2019-04-29\nkey = 1", `"2019-04-29\nkey = 1"`},
+		{"", `""`},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.exp, tomlDate(test.in), test.in)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		n   int
+		s   string
+		exp string
+	}{
+		{10, "Short", "Short"},
+		{10, "  Padded  ", "Padded"},
+		{12, "A sentence that is long", "A sentence..."},
+		{10, "A sentence that is long", "A sentence..."},
+		{5, "Unbroken", "Unbro..."},
+		{4, "Ünïcödé", "Ünïc..."},
+		{-1, "Anything", "Anything"},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.exp, truncate(test.n, test.s), "%d %q", test.n, test.s)
+	}
+}
+
+func TestUpdateHugoTemplate(t *testing.T) {
+	hugoDir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(hugoDir)
+
+	routes, err := loadRoutes(nil, route{Tag: "blog", ContentDir: "content/blog", ImageDir: "/img/posts", Categories: true, NestedTags: nestedSections, InlineTags: inlineTagsText, ShortNotes: shortNotesSkip, Template: "testData/templates/post.md"})
+	require.NoError(t, err)
+
+	created := time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC)
+	runUpdateHugo(t, hugoTest{dir: hugoDir, routes: routes, loc: time.UTC},
+		note{ID: "TRIP", Title: `A "Trip"`, Tagged: true, Created: coreDataTime{Time: created}, BearTags: []string{"blog", "blog/food", "blog/travel/japan"}, BodyRaw: []byte("# A \"Trip\"\n#blog/food #blog/travel/japan\n\nPhotos from a week in Kyoto and Osaka.\n[image:ABC/kyoto.jpg]\n[image:ABC/osaka.jpg]\n```\nnot counted\n```")})
+
+	f, err := ioutil.ReadFile(filepath.Join(hugoDir, "content/blog/travel/japan/a-trip.md"))
+	require.NoError(t, err)
+	require.Equal(t, `---
+title: "A \"Trip\""
+slug: a-trip
+date: 2019-04-01T12:00:00Z
+year: 2019
+tags: ["food"]
+route: blog
+section: travel/japan
+words: 10
+image: "/img/posts/kyoto.jpg"
+summary: "Photos from a week..."
+---
+
+Photos from a week in Kyoto and Osaka.
+![](/img/posts/kyoto.jpg)
+![](/img/posts/osaka.jpg)
+`+"```\nnot counted\n```\n", string(f))
+}
+
+func TestUpdateHugoTemplateFormat(t *testing.T) {
+	hugoDir, err := ioutil.TempDir("", "bhugo")
+	require.NoError(t, err)
+	defer os.RemoveAll(hugoDir)
+
+	tmpl := filepath.Join(hugoDir, "post.md")
+	require.NoError(t, ioutil.WriteFile(tmpl, []byte(`+++
+title = {{ tomlQuote .Title }}
+date = {{ .Date }}
+{{- range $l := .CustomFrontMatter }}
+{{ $l }}
+{{- end }}
++++
+{{ .Body }}`), 0666))
+
+	fp := filepath.Join(hugoDir, "content", "post.md")
+	require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0755))
+	require.NoError(t, ioutil.WriteFile(fp, []byte(`---
+title: Post
+date: 2019-04-29T10:00:00Z
+author: me
+---
+
+Old text`), 0666))
+
+	routes, err := loadRoutes(nil, route{Tag: "blog", ContentDir: "content", ImageDir: "/", NestedTags: nestedFlat, InlineTags: inlineTagsText, ShortNotes: shortNotesSkip, Template: tmpl})
+	require.NoError(t, err)
+
+	runUpdateHugo(t, hugoTest{dir: hugoDir, routes: routes},
+		note{ID: "POST", Title: "Post", Tagged: true, BodyRaw: []byte("# Post\n#blog\n\nNew text")})
+
+	f, err := ioutil.ReadFile(fp)
+	require.NoError(t, err)
+	require.Equal(t, `+++
+title = "Post"
+date = 2019-04-29T10:00:00Z
+author = "me"
++++
+
+New text`, string(f))
+}
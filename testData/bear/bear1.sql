@@ -0,0 +1,19 @@
+-- Core Data schema of a Bear 1 database, trimmed to the tables Bhugo reads.
+CREATE TABLE Z_PRIMARYKEY (Z_ENT INTEGER PRIMARY KEY, Z_NAME VARCHAR, Z_SUPER INTEGER, Z_MAX INTEGER);
+CREATE TABLE Z_METADATA (Z_VERSION INTEGER PRIMARY KEY, Z_UUID VARCHAR(255), Z_PLIST BLOB);
+CREATE TABLE ZSFNOTE (
+	Z_PK INTEGER PRIMARY KEY, Z_ENT INTEGER, Z_OPT INTEGER,
+	ZARCHIVED INTEGER DEFAULT 0, ZENCRYPTED INTEGER DEFAULT 0, ZHASFILES INTEGER DEFAULT 0, ZHASIMAGES INTEGER DEFAULT 0,
+	ZLOCKED INTEGER DEFAULT 0, ZPERMANENTLYDELETED INTEGER DEFAULT 0, ZPINNED INTEGER DEFAULT 0, ZTRASHED INTEGER DEFAULT 0,
+	ZARCHIVEDDATE TIMESTAMP, ZCREATIONDATE TIMESTAMP, ZMODIFICATIONDATE TIMESTAMP, ZPINNEDDATE TIMESTAMP, ZTRASHEDDATE TIMESTAMP,
+	ZLASTEDITINGDEVICE VARCHAR, ZSUBTITLE VARCHAR, ZTEXT VARCHAR, ZTITLE VARCHAR, ZUNIQUEIDENTIFIER VARCHAR
+);
+CREATE TABLE ZSFNOTETAG (Z_PK INTEGER PRIMARY KEY, Z_ENT INTEGER, Z_OPT INTEGER, ZMODIFICATIONDATE TIMESTAMP, ZTITLE VARCHAR);
+CREATE TABLE ZSFNOTEFILE (
+	Z_PK INTEGER PRIMARY KEY, Z_ENT INTEGER, Z_OPT INTEGER, ZNOTE INTEGER,
+	ZCREATIONDATE TIMESTAMP, ZMODIFICATIONDATE TIMESTAMP, ZFILENAME VARCHAR, ZNORMALIZEDFILEEXTENSION VARCHAR, ZUNIQUEIDENTIFIER VARCHAR
+);
+CREATE TABLE Z_7TAGS (Z_7NOTES INTEGER, Z_14TAGS INTEGER, PRIMARY KEY (Z_7NOTES, Z_14TAGS));
+CREATE INDEX Z_7TAGS_Z_14TAGS_INDEX ON Z_7TAGS (Z_14TAGS, Z_7NOTES);
+
+INSERT INTO Z_PRIMARYKEY VALUES (7, 'SFNote', 0, 0), (9, 'SFNoteFile', 0, 0), (14, 'SFNoteTag', 0, 0);
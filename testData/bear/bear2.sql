@@ -0,0 +1,23 @@
+-- Core Data schema of a Bear 2 database, trimmed to the tables Bhugo reads.
+CREATE TABLE Z_PRIMARYKEY (Z_ENT INTEGER PRIMARY KEY, Z_NAME VARCHAR, Z_SUPER INTEGER, Z_MAX INTEGER);
+CREATE TABLE Z_METADATA (Z_VERSION INTEGER PRIMARY KEY, Z_UUID VARCHAR(255), Z_PLIST BLOB);
+CREATE TABLE ZSFNOTE (
+	Z_PK INTEGER PRIMARY KEY, Z_ENT INTEGER, Z_OPT INTEGER,
+	ZARCHIVED INTEGER DEFAULT 0, ZENCRYPTED INTEGER DEFAULT 0, ZHASFILES INTEGER DEFAULT 0, ZHASIMAGES INTEGER DEFAULT 0,
+	ZLOCKED INTEGER DEFAULT 0, ZPERMANENTLYDELETED INTEGER DEFAULT 0, ZPINNED INTEGER DEFAULT 0, ZTRASHED INTEGER DEFAULT 0,
+	ZVERSION INTEGER, ZARCHIVEDDATE TIMESTAMP, ZCREATIONDATE TIMESTAMP, ZMODIFICATIONDATE TIMESTAMP, ZPINNEDDATE TIMESTAMP,
+	ZTRASHEDDATE TIMESTAMP, ZLASTEDITINGDEVICE VARCHAR, ZSUBTITLE VARCHAR, ZTEXT VARCHAR, ZTITLE VARCHAR, ZUNIQUEIDENTIFIER VARCHAR
+);
+CREATE TABLE ZSFNOTETAG (
+	Z_PK INTEGER PRIMARY KEY, Z_ENT INTEGER, Z_OPT INTEGER, ZISROOT INTEGER, ZPINNED INTEGER,
+	ZMODIFICATIONDATE TIMESTAMP, ZTAGCON VARCHAR, ZTITLE VARCHAR, ZUNIQUEIDENTIFIER VARCHAR
+);
+CREATE TABLE ZSFNOTEFILE (
+	Z_PK INTEGER PRIMARY KEY, Z_ENT INTEGER, Z_OPT INTEGER, ZNOTE INTEGER,
+	ZCREATIONDATE TIMESTAMP, ZMODIFICATIONDATE TIMESTAMP, ZFILENAME VARCHAR, ZNORMALIZEDFILEEXTENSION VARCHAR, ZUNIQUEIDENTIFIER VARCHAR
+);
+CREATE TABLE ZSFNOTEBACKLINK (Z_PK INTEGER PRIMARY KEY, Z_ENT INTEGER, Z_OPT INTEGER, ZLINKEDBY INTEGER, ZLINKINGTO INTEGER, ZTITLE VARCHAR);
+CREATE TABLE Z_5TAGS (Z_5NOTES INTEGER, Z_13TAGS INTEGER, PRIMARY KEY (Z_5NOTES, Z_13TAGS));
+CREATE INDEX Z_5TAGS_Z_13TAGS_INDEX ON Z_5TAGS (Z_13TAGS, Z_5NOTES);
+
+INSERT INTO Z_PRIMARYKEY VALUES (5, 'SFNote', 0, 0), (7, 'SFNoteBackLink', 0, 0), (9, 'SFNoteFile', 0, 0), (13, 'SFNoteTag', 0, 0);
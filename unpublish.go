@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Actions that can be taken on the Hugo file of a note that is no longer published.
+const (
+	actionKeep    = "keep"
+	actionDelete  = "delete"
+	actionDraft   = "draft"
+	actionArchive = "archive"
+)
+
+var removalActions = map[string]bool{
+	actionKeep:    true,
+	actionDelete:  true,
+	actionDraft:   true,
+	actionArchive: true,
+}
+
+// removalPolicy decides what happens to a note's Hugo file when it's
+// trashed, archived or loses its tag in Bear.
+type removalPolicy struct {
+	Trashed  string
+	Archived string
+	Untagged string
+	// Directory relative to the Hugo directory that archived posts are moved to.
+	ArchiveDir string
+}
+
+func (rp removalPolicy) validate() error {
+	for _, a := range []string{rp.Trashed, rp.Archived, rp.Untagged} {
+		if !removalActions[a] {
+			return fmt.Errorf("unknown removal action %q, expected one of keep, delete, draft or archive", a)
+		}
+	}
+
+	return nil
+}
+
+// apply carries out the policy for a note that is no longer published.
+func (rp removalPolicy) apply(n note, hugoDir string, st *state) {
+	ns, ok := st.get(n.ID)
+	if !ok {
+		return
+	}
+
+	action, reason := rp.Untagged, "untagged"
+	switch {
+	case n.Trashed:
+		action, reason = rp.Trashed, "trashed"
+	case n.Archived:
+		action, reason = rp.Archived, "archived"
+	}
+
+	fp := filepath.Join(hugoDir, ns.Path)
+
+	// The hash is cleared so the note is exported again if it's ever republished.
+	ns.Hash = ""
+	ns.Modified = n.Modified.Time
+
+	switch action {
+	case actionKeep:
+		log.Infof("%s was %s - leaving %s as it is", n.Title, reason, fp)
+
+	case actionDelete:
+		log.Infof("%s was %s - deleting %s", n.Title, reason, fp)
+		if err := removeNote(fp, ns.Bundle); err != nil {
+			log.Error(err)
+			return
+		}
+
+		if err := st.remove(n.ID); err != nil {
+			log.Error(err)
+		}
+		return
+
+	case actionDraft:
+		log.Infof("%s was %s - marking %s as a draft", n.Title, reason, fp)
+		f, err := ioutil.ReadFile(fp)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		if err := ioutil.WriteFile(fp, setDraft(f), 0666); err != nil {
+			log.Error(err)
+			return
+		}
+
+	case actionArchive:
+		// Bundles are archived as a whole.
+		from, name := fp, filepath.Base(ns.Path)
+		ns.Path = filepath.Join(rp.ArchiveDir, name)
+		if ns.Bundle {
+			from, name = filepath.Dir(fp), filepath.Base(filepath.Dir(fp))
+			ns.Path = filepath.Join(rp.ArchiveDir, name, filepath.Base(fp))
+		}
+		to := filepath.Join(hugoDir, rp.ArchiveDir, name)
+
+		log.Infof("%s was %s - moving %s to %s", n.Title, reason, from, to)
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			log.Error(err)
+			return
+		}
+
+		if err := os.Rename(from, to); err != nil {
+			log.Error(err)
+			return
+		}
+	}
+
+	if err := st.set(n.ID, ns); err != nil {
+		log.Error(err)
+	}
+}
+
+// setDraft marks the post as a draft in its front matter.
+func setDraft(f []byte) []byte {
+	d, rest, ok := readFrontMatter(f)
+	if !ok {
+		return f
+	}
+
+	d.set("draft", "true")
+	return append(d.bytes(), rest...)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemovalPolicy(t *testing.T) {
+	post := `---
+title: "Post"
+draft: false
+custom: abc
+---
+
+Body text`
+
+	tests := []struct {
+		name   string
+		in     note
+		exp    map[string]string
+		expSt  map[string]noteState
+		policy removalPolicy
+	}{
+		{
+			"keep",
+			note{ID: "NOTE", Title: "Post", Tagged: true, Trashed: true},
+			map[string]string{"content/blog/post.md": post},
+			map[string]noteState{"NOTE": {Path: "content/blog/post.md"}},
+			removalPolicy{Trashed: actionKeep},
+		},
+		{
+			"delete",
+			note{ID: "NOTE", Title: "Post", Tagged: true, Archived: true},
+			map[string]string{},
+			map[string]noteState{},
+			removalPolicy{Archived: actionDelete},
+		},
+		{
+			"draft",
+			note{ID: "NOTE", Title: "Post"},
+			map[string]string{"content/blog/post.md": `---
+title: "Post"
+draft: true
+custom: abc
+---
+
+Body text`},
+			map[string]noteState{"NOTE": {Path: "content/blog/post.md"}},
+			removalPolicy{Untagged: actionDraft},
+		},
+		{
+			"archive",
+			note{ID: "NOTE", Title: "Post", Trashed: true},
+			map[string]string{"content/archive/post.md": post},
+			map[string]noteState{"NOTE": {Path: "content/archive/post.md"}},
+			removalPolicy{Trashed: actionArchive, ArchiveDir: "content/archive"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hugoDir, err := ioutil.TempDir("", "bhugo")
+			require.NoError(t, err)
+			defer os.RemoveAll(hugoDir)
+
+			fp := filepath.Join(hugoDir, "content/blog/post.md")
+			require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0755))
+			require.NoError(t, ioutil.WriteFile(fp, []byte(post), 0666))
+
+			st := &state{Notes: map[string]noteState{
+				"NOTE": {Path: "content/blog/post.md", Hash: "abc", Modified: time.Now()},
+			}}
+
+			test.policy.apply(test.in, hugoDir, st)
+
+			require.Equal(t, test.exp, listFiles(t, hugoDir))
+			require.Equal(t, test.expSt, st.Notes)
+		})
+	}
+}
+
+func TestRemovalPolicyBundles(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy removalPolicy
+		exp    map[string]string
+		expSt  map[string]noteState
+	}{
+		{
+			"delete",
+			removalPolicy{Trashed: actionDelete},
+			map[string]string{"content/blog/other.md": "other"},
+			map[string]noteState{},
+		},
+		{
+			"archive",
+			removalPolicy{Trashed: actionArchive, ArchiveDir: "content/archive"},
+			map[string]string{"content/archive/post/index.md": "post", "content/archive/post/photo.jpg": "photo", "content/blog/other.md": "other"},
+			map[string]noteState{"NOTE": {Path: "content/archive/post/index.md", Bundle: true}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hugoDir, err := ioutil.TempDir("", "bhugo")
+			require.NoError(t, err)
+			defer os.RemoveAll(hugoDir)
+
+			for f, c := range map[string]string{"content/blog/post/index.md": "post", "content/blog/post/photo.jpg": "photo", "content/blog/other.md": "other"} {
+				fp := filepath.Join(hugoDir, f)
+				require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0755))
+				require.NoError(t, ioutil.WriteFile(fp, []byte(c), 0666))
+			}
+
+			st := &state{Notes: map[string]noteState{
+				"NOTE": {Path: "content/blog/post/index.md", Bundle: true, Hash: "abc"},
+			}}
+
+			test.policy.apply(note{ID: "NOTE", Title: "Post", Tagged: true, Trashed: true}, hugoDir, st)
+
+			require.Equal(t, test.exp, listFiles(t, hugoDir))
+			require.Equal(t, test.expSt, st.Notes)
+		})
+	}
+}
+
+func TestSetDraft(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		exp  string
+	}{
+		{"empty", "", ""},
+		{
+			"existing draft",
+			"---\ntitle: \"Post\"\ndraft: false\n---\n\nBody text",
+			"---\ntitle: \"Post\"\ndraft: true\n---\n\nBody text",
+		},
+		{
+			"no draft",
+			"---\ntitle: \"Post\"\n---\n\nBody text",
+			"---\ntitle: \"Post\"\ndraft: true\n---\n\nBody text",
+		},
+		{
+			"nested draft",
+			"---\ntitle: \"Post\"\nparams:\n  draft: false\n---\n\nBody text",
+			"---\ntitle: \"Post\"\nparams:\n  draft: false\ndraft: true\n---\n\nBody text",
+		},
+		{
+			"toml",
+			"+++\ntitle = \"Post\"\ndraft = false\n+++\n\nBody text",
+			"+++\ntitle = \"Post\"\ndraft = true\n+++\n\nBody text",
+		},
+		{
+			"json",
+			"{\n  \"title\": \"Post\"\n}\n\nBody text",
+			"{\n  \"title\": \"Post\",\n  \"draft\": true\n}\n\nBody text",
+		},
+		{
+			"no front matter",
+			"Body text\ndraft: false",
+			"Body text\ndraft: false",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.exp, string(setDraft([]byte(test.in))))
+		})
+	}
+}
+
+// listFiles reads every file under dir, keyed by its slash separated path in dir.
+func listFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		f, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(f)
+		return err
+	})
+	require.NoError(t, err)
+
+	return files
+}
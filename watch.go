@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// watchDatabase signals on the returned channel once writes to the Bear database
+// or its write-ahead log have settled for the debounce period.
+func watchDatabase(stop <-chan struct{}, database string, debounce time.Duration) (<-chan struct{}, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite recreates the write-ahead log, so watch the directory rather than the files.
+	if err := w.Add(filepath.Dir(database)); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	files := map[string]bool{
+		filepath.Clean(database):          true,
+		filepath.Clean(database + "-wal"): true,
+	}
+
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		defer w.Close()
+
+		// The timer only starts once there has been a write.
+		timer := time.NewTimer(debounce)
+		timer.Stop()
+
+		for {
+			select {
+			case e := <-w.Events:
+				if !files[filepath.Clean(e.Name)] || e.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				timer.Reset(debounce)
+
+			case <-timer.C:
+				notify(changes)
+
+			case err := <-w.Errors:
+				log.Warn(err)
+
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+// pollDatabase signals on the returned channel at every interval.
+func pollDatabase(stop <-chan struct{}, interval time.Duration) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-tick.C:
+				notify(changes)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return changes
+}
+
+// notify signals a change without blocking. Changes that arrive while one is
+// still pending are coalesced since a single check picks them all up.
+func notify(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}